@@ -0,0 +1,699 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"re-asmr-spider/config"
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/jobs"
+	"re-asmr-spider/server"
+	"re-asmr-spider/spider"
+	"re-asmr-spider/utils"
+	"re-asmr-spider/version"
+)
+
+// 命令行模式的退出码
+const (
+	exitOK          = 0   // 全部成功
+	exitError       = 1   // 登录失败、配置错误等
+	exitUsage       = 2   // 参数错误
+	exitIncomplete  = 3   // 部分文件达到最大重试次数仍失败
+	exitInterrupted = 130 // 收到 SIGINT/SIGTERM，保存进度后退出（128+SIGINT）
+)
+
+// runCommand 执行子命令并返回进程退出码
+func runCommand(args []string) int {
+	cmd, rest := args[0], args[1:]
+	switch cmd {
+	case "download":
+		return cmdDownload(rest)
+	case "resume":
+		return cmdResume(rest)
+	case "plan":
+		return cmdPlan(rest)
+	case "search":
+		return cmdSearch(rest)
+	case "sync":
+		return cmdSync(rest)
+	case "serve":
+		return cmdServe(rest)
+	case "status":
+		return cmdStatus(rest)
+	case "config":
+		return cmdConfig(rest)
+	case "version", "-v", "--version":
+		fmt.Printf("%s %s (%s, %s)\n", version.AppName, version.GetFullVersion(), version.GitCommit, version.BuildTime)
+		return exitOK
+	case "help", "-h", "--help":
+		printUsage()
+		return exitOK
+	default:
+		utils.Error(i18n.T("cli_unknown_command", cmd))
+		printUsage()
+		return exitUsage
+	}
+}
+
+func printUsage() {
+	for _, line := range i18n.TList("cli_usage") {
+		fmt.Println(strings.ReplaceAll(line, "{{app_name}}", os.Args[0]))
+	}
+}
+
+// downloadExitCode 将 executeDownload 的结果转换为退出码
+func downloadExitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errDownloadIncomplete):
+		return exitIncomplete
+	case errors.Is(err, errInterrupted):
+		return exitInterrupted
+	default:
+		return exitError
+	}
+}
+
+func cmdDownload(args []string) int {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	from := fs.String("from", "", "")
+	filters := addFilterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	tasks, err := taskArgs(fs, *from)
+	if err != nil {
+		utils.Error(i18n.T("read_input_failed", err))
+		return exitError
+	}
+	if len(tasks) == 0 {
+		utils.Error(i18n.T("no_rj_input"))
+		return exitUsage
+	}
+	if err := filters.apply(fs, tasks); err != nil {
+		utils.Error(i18n.T("invalid_value")+": %v", err)
+		return exitUsage
+	}
+	return downloadExitCode(executeDownload(tasks))
+}
+
+// cmdPlan 只列出将要下载的文件，不下载
+func cmdPlan(args []string) int {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "")
+	from := fs.String("from", "", "")
+	filters := addFilterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	tasks, err := taskArgs(fs, *from)
+	if err != nil {
+		utils.Error(i18n.T("read_input_failed", err))
+		return exitError
+	}
+	if len(tasks) == 0 {
+		utils.Error(i18n.T("no_rj_input"))
+		return exitUsage
+	}
+	if err := filters.apply(fs, tasks); err != nil {
+		utils.Error(i18n.T("invalid_value")+": %v", err)
+		return exitUsage
+	}
+	// stdout 只输出 JSON，日志改到 stderr
+	if *asJSON {
+		utils.SetOutput(os.Stderr)
+	}
+
+	c := spider.NewASMRClient(context.Background(), spider.Conf.MaxTask, spider.Conf.MaxThread, spider.Conf.MaxRetry)
+	if err := c.Login(); err != nil {
+		utils.Error(i18n.T("login_failed", err))
+		return exitError
+	}
+
+	code := exitOK
+	plans := make([]*spider.Plan, 0, len(tasks))
+	for _, task := range tasks {
+		rj, err := spider.NormalizeRJ(task)
+		if err != nil {
+			utils.Error(i18n.T("inputs_ignored", task))
+			code = exitError
+			continue
+		}
+		utils.Info(i18n.T("fetching_work_info", rj))
+		plan, err := c.Plan(strings.TrimPrefix(rj, "RJ"), spider.PlanOptions{Sizes: true, CheckExisting: true})
+		if err != nil {
+			utils.Error(i18n.T("plan_failed", rj, err))
+			code = exitError
+			continue
+		}
+		plans = append(plans, plan)
+	}
+
+	if *asJSON {
+		data, err := json.MarshalIndent(plans, "", "  ")
+		if err != nil {
+			utils.Error(i18n.T("parse_error", err))
+			return exitError
+		}
+		fmt.Println(string(data))
+		return code
+	}
+
+	var total spider.PlanSummary
+	for _, plan := range plans {
+		printPlan(plan)
+		total.Merge(&plan.Summary)
+	}
+	if len(plans) > 1 {
+		fmt.Println(i18n.T("plan_total", planSummaryText(&total)))
+	}
+	return code
+}
+
+// cmdSearch 搜索作品并确认后批量下载，剩余参数作为关键词
+func cmdSearch(args []string) int {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	tags := fs.String("tag", "", "")
+	vas := fs.String("va", "", "")
+	circles := fs.String("circle", "", "")
+	since := fs.String("since", "", "")
+	until := fs.String("until", "", "")
+	maxCount := fs.Int("max", 50, "")
+	yes := fs.Bool("yes", false, "")
+	filters := addFilterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	opts := spider.SearchOptions{
+		Keyword: strings.Join(fs.Args(), " "),
+		Tags:    splitList(*tags),
+		VAs:     splitList(*vas),
+		Circles: splitList(*circles),
+		Max:     *maxCount,
+	}
+	var err error
+	if opts.Since, err = parseSearchDate(*since); err != nil {
+		utils.Error(i18n.T("invalid_value")+": --since %v", err)
+		return exitUsage
+	}
+	if opts.Until, err = parseSearchDate(*until); err != nil {
+		utils.Error(i18n.T("invalid_value")+": --until %v", err)
+		return exitUsage
+	}
+	if opts.Max < 0 {
+		utils.Error(i18n.T("invalid_value")+": --max %d", opts.Max)
+		return exitUsage
+	}
+	if opts.Query() == "" {
+		utils.Error(i18n.T("search_empty"))
+		return exitUsage
+	}
+	// 非交互模式无法确认，必须显式加 --yes
+	if !*yes && !interactive {
+		utils.Error(i18n.T("search_confirm_required"))
+		return exitUsage
+	}
+
+	c := spider.NewASMRClient(context.Background(), spider.Conf.MaxTask, spider.Conf.MaxThread, spider.Conf.MaxRetry)
+	if err := c.Login(); err != nil {
+		utils.Error(i18n.T("login_failed", err))
+		return exitError
+	}
+	utils.Info(i18n.T("search_query", opts.Query()))
+	works, total, err := c.Search(opts)
+	if err != nil {
+		utils.Error(i18n.T("search_failed", err))
+		return exitError
+	}
+	if len(works) == 0 {
+		utils.Info(i18n.T("search_no_result"))
+		return exitOK
+	}
+
+	tasks := make([]string, 0, len(works))
+	for _, w := range works {
+		tasks = append(tasks, w.RJ())
+		fmt.Printf("%-10s  %s  %s  %s\n", w.RJ(), w.Release, w.Circle.Name, w.Title)
+	}
+	utils.Info(i18n.T("search_summary", total, len(works)))
+
+	if !*yes {
+		choice := strings.ToLower(readInput(i18n.T("search_confirm_prompt", len(tasks))))
+		if choice != "y" && choice != "yes" {
+			utils.Info(i18n.T("download_cancelled"))
+			return exitOK
+		}
+	}
+	if err := filters.apply(fs, tasks); err != nil {
+		utils.Error(i18n.T("invalid_value")+": %v", err)
+		return exitUsage
+	}
+	return downloadExitCode(executeDownload(tasks))
+}
+
+// cmdSync 下载关注的社团和声优尚未下载过的新作品，不需要确认，适合定时运行
+func cmdSync(args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "")
+	maxCount := fs.Int("max", 0, "")
+	filters := addFilterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	watch := spider.Conf.Watch
+	if len(watch.Circles) == 0 && len(watch.VAs) == 0 {
+		utils.Error(i18n.T("sync_empty"))
+		return exitUsage
+	}
+
+	c := spider.NewASMRClient(context.Background(), spider.Conf.MaxTask, spider.Conf.MaxThread, spider.Conf.MaxRetry)
+	if err := c.Login(); err != nil {
+		utils.Error(i18n.T("login_failed", err))
+		return exitError
+	}
+	works, failed, err := c.NewReleases(watch)
+	if err != nil {
+		utils.Error(i18n.T("invalid_value")+": watch.since %v", err)
+		return exitUsage
+	}
+	if *maxCount > 0 && len(works) > *maxCount {
+		works = works[:*maxCount]
+	}
+
+	tasks := make([]string, 0, len(works))
+	for _, w := range works {
+		tasks = append(tasks, w.RJ())
+		fmt.Printf("%-10s  %s  %s  %s\n", w.RJ(), w.Release, w.Circle.Name, w.Title)
+	}
+	utils.Info(i18n.T("sync_summary", len(tasks)))
+
+	// 有查询失败时以错误码结束，便于定时任务发现问题
+	code := exitOK
+	if failed > 0 {
+		code = exitError
+	}
+	if len(tasks) == 0 || *dryRun {
+		return code
+	}
+	if err := filters.apply(fs, tasks); err != nil {
+		utils.Error(i18n.T("invalid_value")+": %v", err)
+		return exitUsage
+	}
+	if dl := downloadExitCode(executeDownload(tasks)); dl != exitOK {
+		return dl
+	}
+	return code
+}
+
+// cmdServe 守护进程模式，通过本地 HTTP API 提交和管理下载
+func cmdServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", spider.Conf.Daemon.Listen, "")
+	token := fs.String("token", spider.Conf.Daemon.Token, "")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	// SIGINT/SIGTERM 时停止接受请求，等待正在下载的分块落盘后退出
+	ctx, stop := notifyShutdown()
+	defer stop()
+
+	c := spider.NewASMRClient(ctx, spider.Conf.MaxTask, spider.Conf.MaxThread, spider.Conf.MaxRetry)
+	c.WorkerPool.Start()
+	if err := c.Login(); err != nil {
+		utils.Error(i18n.T("login_failed", err))
+		return exitError
+	}
+
+	utils.Throttle.Start()
+	defer utils.Throttle.Stop()
+
+	if err := server.New(c, *token).ListenAndServe(ctx, *listen); err != nil {
+		utils.Error(i18n.T("daemon_failed", err))
+		return exitError
+	}
+	c.WorkerPool.Wait()
+	c.WorkerPool.Close()
+
+	unfinished := 0
+	for _, job := range spider.Jobs.List("") {
+		if job.State != jobs.StateUploaded && !job.State.Stopped() {
+			unfinished++
+		}
+	}
+	utils.Warning(i18n.T("daemon_stopped", unfinished))
+	return exitOK
+}
+
+// parseSearchDate 解析 YYYY-MM-DD，空字符串返回零值
+func parseSearchDate(s string) (time.Time, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(spider.SearchDateLayout, s)
+}
+
+// printPlan 以树形打印下载计划
+func printPlan(plan *spider.Plan) {
+	fmt.Printf("%s → %s\n", plan.RJ, plan.BasePath)
+	printPlanNodes(plan.Root.Children, "")
+	fmt.Println(planSummaryText(&plan.Summary))
+	fmt.Println()
+}
+
+func printPlanNodes(nodes []*spider.PlanNode, indent string) {
+	for i, node := range nodes {
+		branch, next := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, next = "└── ", "    "
+		}
+		if node.Folder {
+			fmt.Printf("%s%s%s/  [%s]%s\n", indent, branch, node.Name,
+				utils.FormatSize(node.Summary.Download.Size), planMark(node))
+			printPlanNodes(node.Children, indent+next)
+			continue
+		}
+		size := "?"
+		if node.Size > 0 {
+			size = utils.FormatSize(node.Size)
+		}
+		fmt.Printf("%s%s%s  %s%s\n", indent, branch, node.Name, size, planMark(node))
+	}
+}
+
+// planMark 文件或文件夹后的状态标记，正常下载的文件不加标记
+func planMark(node *spider.PlanNode) string {
+	switch node.Action {
+	case spider.PlanPresent:
+		return "  " + i18n.T("plan_present")
+	case spider.PlanDeduped:
+		return "  " + i18n.T("plan_deduped")
+	case spider.PlanFiltered:
+		return "  " + i18n.T("plan_filtered_"+node.Reason)
+	}
+	return ""
+}
+
+func planSummaryText(s *spider.PlanSummary) string {
+	return i18n.T("plan_summary",
+		s.Download.Files, utils.FormatSize(s.Download.Size),
+		s.Present.Files, utils.FormatSize(s.Present.Size),
+		s.Filtered.Files+s.Deduped.Files, utils.FormatSize(s.Filtered.Size+s.Deduped.Size))
+}
+
+// taskArgs 从参数和 --from 指定的文件（- 为标准输入）中提取 RJ 号
+// 参数可以是 RJ 号、asmr.one/DLsite 链接或逗号分隔的列表，无法识别的参数会提示后忽略
+func taskArgs(fs *flag.FlagSet, from string) ([]string, error) {
+	tasks := extractTasks(strings.Join(fs.Args(), "\n"), true)
+	if from == "" {
+		return tasks, nil
+	}
+
+	var data []byte
+	var err error
+	if from == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(from)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, rj := range tasks {
+		seen[rj] = true
+	}
+	for _, rj := range extractTasks(string(data), false) {
+		if !seen[rj] {
+			seen[rj] = true
+			tasks = append(tasks, rj)
+		}
+	}
+	return tasks, nil
+}
+
+// extractTasks 提取 RJ 号并提示被跳过的输入
+// strict 为 true 时（命令行参数、手动输入）不含编号的片段也会提示，文件内容只提示位数不对的编号
+func extractTasks(text string, strict bool) []string {
+	res := spider.ExtractRJ(text)
+	if len(res.Unsupported) > 0 {
+		utils.Warning(i18n.T("unsupported_codes", strings.Join(res.Unsupported, ", ")))
+	}
+	ignored := res.Invalid
+	if strict {
+		ignored = append(ignored, res.Ignored...)
+	}
+	if len(ignored) > 0 {
+		utils.Warning(i18n.T("inputs_ignored", strings.Join(ignored, ", ")))
+	}
+	return res.RJs
+}
+
+// filterFlags download 与 plan 共用的过滤选项
+type filterFlags struct {
+	includeExt    *string
+	excludeExt    *string
+	excludeFolder *string
+	maxSize       *string
+	prefer        *string
+}
+
+func addFilterFlags(fs *flag.FlagSet) *filterFlags {
+	return &filterFlags{
+		includeExt:    fs.String("include-ext", "", ""),
+		excludeExt:    fs.String("exclude-ext", "", ""),
+		excludeFolder: fs.String("exclude-folder", "", ""),
+		maxSize:       fs.String("max-size", "", ""),
+		prefer:        fs.String("prefer", "", ""),
+	}
+}
+
+// apply 命令行指定的过滤规则只覆盖给出的字段，作用于本次的所有作品
+func (ff *filterFlags) apply(fs *flag.FlagSet, tasks []string) error {
+	filter := spider.Conf.Filter
+	overridden := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "include-ext":
+			filter.IncludeExt = splitList(*ff.includeExt)
+		case "exclude-ext":
+			filter.ExcludeExt = splitList(*ff.excludeExt)
+		case "exclude-folder":
+			filter.ExcludeFolders = splitList(*ff.excludeFolder)
+		case "max-size":
+			filter.MaxSize = *ff.maxSize
+		case "prefer":
+			filter.PreferFormats = splitList(*ff.prefer)
+		default:
+			return
+		}
+		overridden = true
+	})
+	if !overridden {
+		return nil
+	}
+	if _, err := spider.NewFilter(filter); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		spider.SetFilterOverride("RJ"+strings.TrimPrefix(strings.ToUpper(task), "RJ"), filter)
+	}
+	return nil
+}
+
+// splitList 解析逗号分隔的列表
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// validateFilters 检查全局和按作品的过滤规则
+func validateFilters(cfg *config.Config) error {
+	if _, err := spider.NewFilter(cfg.Filter); err != nil {
+		return err
+	}
+	for rj, f := range cfg.FilterOverrides {
+		if _, err := spider.NewFilter(f); err != nil {
+			return fmt.Errorf("%s: %v", rj, err)
+		}
+	}
+	return nil
+}
+
+func cmdResume(args []string) int {
+	fs := flag.NewFlagSet("resume", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	tasks := unfinishedTasks()
+	if len(tasks) == 0 {
+		utils.Info(i18n.T("cli_nothing_to_resume"))
+		return exitOK
+	}
+	utils.Info(i18n.T("unfinished_tasks", strings.Join(tasks, ", ")))
+	return downloadExitCode(executeDownload(tasks))
+}
+
+// unfinishedTasks 合并上次的下载状态与任务库中仍有未完成文件的作品
+func unfinishedTasks() []string {
+	tasks := make([]string, 0)
+	seen := make(map[string]bool)
+	state := spider.Conf.DownloadState
+	if state.InProgress {
+		for _, task := range state.Tasks {
+			if !seen[task] {
+				seen[task] = true
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	for _, rj := range spider.Jobs.PendingRJs() {
+		if !seen[rj] {
+			seen[rj] = true
+			tasks = append(tasks, rj)
+		}
+	}
+	return tasks
+}
+
+func cmdStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if tasks := unfinishedTasks(); len(tasks) > 0 {
+		utils.Warning(i18n.T("unfinished_download_detected"))
+		utils.Info(i18n.T("unfinished_tasks", strings.Join(tasks, ", ")))
+	} else {
+		utils.Info(i18n.T("cli_nothing_to_resume"))
+	}
+
+	// 按状态统计任务库中的文件
+	counts := make(map[jobs.State]int)
+	all := spider.Jobs.List("")
+	for _, job := range all {
+		counts[job.State]++
+	}
+	utils.Info(i18n.T("status_jobs_summary", len(all),
+		counts[jobs.StateQueued]+counts[jobs.StateDownloading], counts[jobs.StateStaged],
+		counts[jobs.StateUploaded], counts[jobs.StateFailed]))
+	for _, job := range all {
+		if job.State == jobs.StateFailed {
+			utils.Error("  - %s (%s)", job.FinalPath, job.Error)
+		}
+	}
+	return exitOK
+}
+
+func cmdConfig(args []string) int {
+	if len(args) == 0 {
+		args = []string{"show"}
+	}
+
+	cfg := spider.Conf
+	switch args[0] {
+	case "show":
+		// 密码、API token 等敏感字段只显示长度
+		shown := *cfg
+		shown.Password = maskSecret(cfg.Password)
+		shown.Rclone.Pass = maskSecret(cfg.Rclone.Pass)
+		shown.Daemon.Token = maskSecret(cfg.Daemon.Token)
+		data, err := json.MarshalIndent(&shown, "", "  ")
+		if err != nil {
+			utils.Error(i18n.T("parse_error", err))
+			return exitError
+		}
+		fmt.Println(string(data))
+		return exitOK
+	case "get":
+		if len(args) != 2 {
+			printUsage()
+			return exitUsage
+		}
+		value, err := config.GetValue(cfg, args[1])
+		if err != nil {
+			utils.Error("%v", err)
+			return exitUsage
+		}
+		data, _ := json.Marshal(value)
+		fmt.Println(string(data))
+		return exitOK
+	case "set":
+		if len(args) != 3 {
+			printUsage()
+			return exitUsage
+		}
+		key, value := args[1], args[2]
+
+		updated := *cfg
+		if err := config.SetValue(&updated, key, value); err != nil {
+			utils.Error("%v", err)
+			return exitUsage
+		}
+		if err := config.Validate(&updated); err != nil {
+			utils.Error(i18n.T("invalid_value")+": %v", err)
+			return exitUsage
+		}
+
+		// 部分配置项需要同步到运行时
+		switch key {
+		case "proxy":
+			if err := utils.SetProxy(updated.Proxy); err != nil {
+				utils.Error(i18n.T("invalid_proxy", err))
+				return exitUsage
+			}
+		case "language":
+			if i18n.GetLanguageByCode(updated.Language) == nil {
+				utils.Error(i18n.T("invalid_value")+": %s", updated.Language)
+				return exitUsage
+			}
+			i18n.SetLocale(updated.Language)
+		case "folder_template":
+			if err := spider.ValidateFolderTemplate(updated.FolderTemplate); err != nil {
+				utils.Error(i18n.T("invalid_value")+": %v", err)
+				return exitUsage
+			}
+		}
+		if strings.HasPrefix(key, "filter") {
+			if err := validateFilters(&updated); err != nil {
+				utils.Error(i18n.T("invalid_value")+": %v", err)
+				return exitUsage
+			}
+		}
+
+		*cfg = updated
+		if err := config.SaveConfig(cfg); err != nil {
+			utils.Error(i18n.T("save_config_failed", err))
+			return exitError
+		}
+		utils.Success(i18n.T("config_saved"))
+		return exitOK
+	default:
+		printUsage()
+		return exitUsage
+	}
+}
+
+// maskSecret 用 * 替换敏感配置项
+func maskSecret(s string) string {
+	return strings.Repeat("*", len(s))
+}
@@ -2,8 +2,13 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type DownloadState struct {
@@ -11,26 +16,153 @@ type DownloadState struct {
 	Tasks      []string `json:"tasks"`
 }
 
+// StorageConfig 下载完成后文件的存储位置
+type StorageConfig struct {
+	// Type local（本地磁盘）、mount（rclone 挂载点）或 rclone_rc（通过 rclone RC 直接上传）
+	Type string `json:"type"`
+	// Root local/mount 为本地目录，rclone_rc 为远程路径，例如 sp:DL
+	Root string `json:"root"`
+	// TempDir 下载时的本地临时目录
+	TempDir string `json:"temp_dir"`
+}
+
+// RcloneConfig rclone RC 接口与 VFS 缓存流控
+type RcloneConfig struct {
+	// APIUrl rclone --rc-addr 对应的地址
+	APIUrl string `json:"api_url"`
+	// User/Pass 对应 --rc-user/--rc-pass，使用 --rc-no-auth 时留空
+	User string `json:"user"`
+	Pass string `json:"pass"`
+	// FlowControl 写入挂载点前是否检查 VFS 缓存占用，没有 rclone 时关闭
+	FlowControl bool `json:"flow_control"`
+	// Adaptive 根据 VFS 上传积压自动降低下载速度，需要同时开启 flow_control
+	Adaptive bool `json:"adaptive"`
+	// PauseThreshold/ResumeThreshold 缓存占用阈值，例如 18G
+	// 设为 auto 时按 --vfs-cache-max-size 的 90%/75% 自动计算
+	PauseThreshold  string `json:"pause_threshold"`
+	ResumeThreshold string `json:"resume_threshold"`
+}
+
+// BandwidthConfig 全局带宽限制，所有并发任务和线程共享
+// 格式与 rclone --bwlimit 相同：单个速率如 50M，或时间表如 "08:00,5M 19:00,off"
+type BandwidthConfig struct {
+	Download string `json:"download"`
+	// Upload 写入挂载点/本地存储时的限速，rclone_rc 存储请使用 rclone 自身的 --bwlimit
+	Upload string `json:"upload"`
+}
+
+// FilterConfig 遍历文件列表时的过滤规则
+type FilterConfig struct {
+	// IncludeExt 只下载这些扩展名（不含点，例如 flac），为空时不限制
+	IncludeExt []string `json:"include_ext"`
+	// ExcludeExt 不下载这些扩展名，例如 psd、mp4
+	ExcludeExt []string `json:"exclude_ext"`
+	// ExcludeFolders 跳过名称匹配的文件夹，不含 * ? 时按包含匹配，例如 "SE無し"
+	ExcludeFolders []string `json:"exclude_folders"`
+	// MaxSize 跳过超过该大小的文件，例如 2G，为空时不限制
+	MaxSize string `json:"max_size"`
+	// PreferFormats 同一内容有多种格式时的偏好顺序，例如 flac、wav、mp3，为空时全部下载
+	PreferFormats []string `json:"prefer_formats"`
+}
+
+// VerifyConfig 写入存储后端后的校验
+type VerifyConfig struct {
+	// Remote 写入后检查存储后端上的文件大小
+	Remote bool `json:"remote"`
+	// Checksum 额外比对校验和 md5/sha1/sha256，为空时不计算
+	Checksum string `json:"checksum"`
+}
+
+// WatchConfig 关注的社团和声优，sync 命令下载其中尚未下载过的新作品
+type WatchConfig struct {
+	Circles []string `json:"circles"`
+	VAs     []string `json:"vas"`
+	// Since 只同步该日期（YYYY-MM-DD）及之后发售的作品，为空时不限制
+	Since string `json:"since"`
+}
+
+// DaemonConfig serve 命令的本地 HTTP API
+type DaemonConfig struct {
+	// Listen 监听地址，默认只监听本机
+	Listen string `json:"listen"`
+	// Token 非空时请求需带 Authorization: Bearer <token>
+	Token string `json:"token"`
+}
+
 type Config struct {
-	Account       string        `json:"account"`
-	Password      string        `json:"password"`
-	MaxTask       int           `json:"max_task"`
-	MaxThread     int           `json:"max_thread"`
-	MaxRetry      int           `json:"max_retry"`
-	Language      string        `json:"language"`
-	Proxy         string        `json:"proxy"`
-	DownloadState DownloadState `json:"download_state"`
+	Account         string                  `json:"account"`
+	Password        string                  `json:"password"`
+	MaxTask         int                     `json:"max_task"`
+	MaxThread       int                     `json:"max_thread"`
+	MaxRetry        int                     `json:"max_retry"`
+	Language        string                  `json:"language"`
+	Proxy           string                  `json:"proxy"`
+	APIHosts        []string                `json:"api_hosts"`
+	MediaHosts      []string                `json:"media_hosts"`
+	Metadata        bool                    `json:"metadata"`
+	FolderTemplate  string                  `json:"folder_template"`
+	Filter          FilterConfig            `json:"filter"`
+	FilterOverrides map[string]FilterConfig `json:"filter_overrides"`
+	Watch           WatchConfig             `json:"watch"`
+	Storage         StorageConfig           `json:"storage"`
+	Rclone          RcloneConfig            `json:"rclone"`
+	Bandwidth       BandwidthConfig         `json:"bwlimit"`
+	Verify          VerifyConfig            `json:"verify"`
+	Daemon          DaemonConfig            `json:"daemon"`
+	DownloadState   DownloadState           `json:"download_state"`
 }
 
 func generateDefaultConfig() *Config {
 	return &Config{
-		Account:   "guest",
-		Password:  "guest",
-		MaxTask:   1,
-		MaxThread: 1,
-		MaxRetry:  3,
-		Language:  "zh-CN",
-		Proxy:     "",
+		Account:        "guest",
+		Password:       "guest",
+		MaxTask:        1,
+		MaxThread:      1,
+		MaxRetry:       3,
+		Language:       "zh-CN",
+		Proxy:          "",
+		Metadata:       true,
+		FolderTemplate: "{rj}",
+		Filter: FilterConfig{
+			IncludeExt:     []string{},
+			ExcludeExt:     []string{},
+			ExcludeFolders: []string{},
+			PreferFormats:  []string{},
+		},
+		FilterOverrides: map[string]FilterConfig{},
+		APIHosts: []string{
+			"https://api.asmr.one",
+			"https://api.asmr-100.com",
+			"https://api.asmr-200.com",
+			"https://api.asmr-300.com",
+		},
+		MediaHosts: []string{},
+		Watch: WatchConfig{
+			Circles: []string{},
+			VAs:     []string{},
+		},
+		Storage: StorageConfig{
+			Type:    "mount",
+			Root:    "downloads",
+			TempDir: "/root/asmr_temp",
+		},
+		Rclone: RcloneConfig{
+			APIUrl:          "http://127.0.0.1:5572",
+			FlowControl:     true,
+			Adaptive:        true,
+			PauseThreshold:  "18G",
+			ResumeThreshold: "15G",
+		},
+		Bandwidth: BandwidthConfig{
+			Download: "50M",
+			Upload:   "off",
+		},
+		Verify: VerifyConfig{
+			Remote: true,
+		},
+		Daemon: DaemonConfig{
+			Listen: "127.0.0.1:8089",
+		},
 		DownloadState: DownloadState{
 			InProgress: false,
 			Tasks:      []string{},
@@ -79,11 +211,137 @@ func GetConfig() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	var config Config
-	err = json.Unmarshal(all, &config)
+	// 以默认配置为底，旧版本配置文件中缺少的字段保持默认值
+	config := generateDefaultConfig()
+	err = json.Unmarshal(all, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &config, nil
+	return config, nil
+}
+
+// Validate 检查配置项取值是否合法
+func Validate(cfg *Config) error {
+	if cfg.MaxTask <= 0 {
+		return errors.New("max_task must be greater than 0")
+	}
+	if cfg.MaxThread <= 0 {
+		return errors.New("max_thread must be greater than 0")
+	}
+	if cfg.MaxRetry < 0 {
+		return errors.New("max_retry must not be negative")
+	}
+	switch cfg.Verify.Checksum {
+	case "", "md5", "sha1", "sha256":
+	default:
+		return errors.New("verify.checksum must be one of md5, sha1, sha256")
+	}
+	if cfg.Watch.Since != "" {
+		if _, err := time.Parse("2006-01-02", cfg.Watch.Since); err != nil {
+			return errors.New("watch.since must be a date like 2024-01-01")
+		}
+	}
+	return nil
+}
+
+// GetValue 按 JSON 字段名读取配置项，支持 a.b 形式的嵌套字段
+func GetValue(cfg *Config, key string) (interface{}, error) {
+	m, err := toMap(cfg)
+	if err != nil {
+		return nil, err
+	}
+	node, last, err := lookup(m, key)
+	if err != nil {
+		return nil, err
+	}
+	return node[last], nil
+}
+
+// SetValue 按 JSON 字段名修改配置项，value 按原字段类型解析
+// 对象类型的配置项（例如 filter_overrides）整体替换，不与原有内容合并
+func SetValue(cfg *Config, key, value string) error {
+	m, err := toMap(cfg)
+	if err != nil {
+		return err
+	}
+	node, last, err := lookup(m, key)
+	if err != nil {
+		return err
+	}
+
+	var parsed interface{}
+	switch node[last].(type) {
+	case string:
+		parsed = value
+	case float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", key, value)
+		}
+		parsed = n
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", key, value)
+		}
+		parsed = b
+	case []interface{}, nil:
+		// 列表既可以写成 JSON 数组，也可以用逗号分隔
+		if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+			items := make([]interface{}, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			parsed = items
+		}
+	default:
+		if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+			return fmt.Errorf("%s: expected a JSON object", key)
+		}
+	}
+	node[last] = parsed
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// 解析到新的结构体，不与 cfg 共用 map 和切片，失败时 cfg 保持不变
+	var updated Config
+	if err := json.Unmarshal(data, &updated); err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	*cfg = updated
+	return nil
+}
+
+func toMap(cfg *Config) (map[string]interface{}, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func lookup(m map[string]interface{}, key string) (map[string]interface{}, string, error) {
+	parts := strings.Split(key, ".")
+	node := m
+	for _, p := range parts[:len(parts)-1] {
+		child, ok := node[p].(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("unknown config key: %s", key)
+		}
+		node = child
+	}
+	last := parts[len(parts)-1]
+	if _, ok := node[last]; !ok {
+		return nil, "", fmt.Errorf("unknown config key: %s", key)
+	}
+	return node, last, nil
 }
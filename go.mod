@@ -2,11 +2,13 @@ module re-asmr-spider
 
 go 1.18
 
-require github.com/schollz/progressbar/v3 v3.14.1
+require (
+	github.com/schollz/progressbar/v3 v3.14.1
+	golang.org/x/term v0.14.0
+)
 
 require (
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
 	golang.org/x/sys v0.14.0 // indirect
-	golang.org/x/term v0.14.0 // indirect
 )
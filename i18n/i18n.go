@@ -4,6 +4,7 @@ import (
 	"embed"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 
 	"re-asmr-spider/version"
@@ -84,18 +85,23 @@ func GetLocale() string {
 	return currentLocale
 }
 
+// lookup 查找当前语言的翻译，语言或词条缺失时回退到中文（调用方需持有读锁）
+func lookup(key string) (interface{}, bool) {
+	if trans, ok := translations[currentLocale]; ok {
+		if value, ok := trans[key]; ok {
+			return value, true
+		}
+	}
+	value, ok := translations["zh-CN"][key]
+	return value, ok
+}
+
 // T 获取翻译文本（支持格式化参数）
 func T(key string, args ...interface{}) string {
 	translationsMux.RLock()
 	defer translationsMux.RUnlock()
 
-	trans, ok := translations[currentLocale]
-	if !ok {
-		// 回退到中文
-		trans = translations["zh-CN"]
-	}
-
-	value, ok := trans[key]
+	value, ok := lookup(key)
 	if !ok {
 		return key // 如果找不到翻译，返回key本身
 	}
@@ -126,13 +132,7 @@ func TList(key string) []string {
 	translationsMux.RLock()
 	defer translationsMux.RUnlock()
 
-	trans, ok := translations[currentLocale]
-	if !ok {
-		// 回退到中文
-		trans = translations["zh-CN"]
-	}
-
-	value, ok := trans[key]
+	value, ok := lookup(key)
 	if !ok {
 		return []string{key}
 	}
@@ -156,6 +156,22 @@ func TList(key string) []string {
 	return []string{key}
 }
 
+// Messages 返回以 prefix 开头的全部词条，locale 缺失的词条回退到中文，供网页面板使用
+func Messages(locale, prefix string) map[string]string {
+	translationsMux.RLock()
+	defer translationsMux.RUnlock()
+
+	result := make(map[string]string)
+	for _, code := range []string{"zh-CN", locale} {
+		for key, value := range translations[code] {
+			if str, ok := value.(string); ok && strings.HasPrefix(key, prefix) {
+				result[key] = str
+			}
+		}
+	}
+	return result
+}
+
 // GetSupportedLocales 获取支持的语言列表
 func GetSupportedLocales() []Language {
 	return globalConfig.Languages
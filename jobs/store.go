@@ -0,0 +1,337 @@
+package jobs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State 单个文件的下载状态
+type State string
+
+const (
+	StateQueued      State = "queued"      // 已加入队列
+	StateDownloading State = "downloading" // 正在下载到本地临时目录
+	StateStaged      State = "staged"      // 临时文件已下载完成，等待移动到最终路径
+	StateUploaded    State = "uploaded"    // 已移动到最终路径（rclone 挂载点）
+	StateFailed      State = "failed"      // 下载或移动失败
+	StatePaused      State = "paused"      // 手动暂停，保留续传进度
+	StateCanceled    State = "canceled"    // 手动取消，不再自动恢复
+)
+
+// Stopped 手动暂停或取消的文件，resume 时不会自动恢复
+func (s State) Stopped() bool {
+	return s == StatePaused || s == StateCanceled
+}
+
+// 文件实际使用的下载地址
+const (
+	SourceDownload = "download" // mediaDownloadUrl
+	SourceStream   = "stream"   // mediaStreamUrl，下载地址为空或返回 403 时使用
+)
+
+// Job 单个文件的下载记录，以最终路径作为唯一标识
+type Job struct {
+	ID         string    `json:"id"`
+	RJ         string    `json:"rj"`
+	URL        string    `json:"url"`
+	StreamURL  string    `json:"stream_url,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	DirPath    string    `json:"dir_path"`
+	FileName   string    `json:"file_name"`
+	TempPath   string    `json:"temp_path"`
+	FinalPath  string    `json:"final_path"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum,omitempty"`
+	State      State     `json:"state"`
+	RetryCount int       `json:"retry_count"`
+	Error      string    `json:"error,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Work 作品级别的记录
+type Work struct {
+	RJ string `json:"rj"`
+	// BasePath 作品目录（相对于存储根目录），由目录模板生成
+	BasePath string `json:"base_path,omitempty"`
+	// Enumerated 文件列表已经全部写入任务库，恢复时无需再请求 /api/tracks
+	Enumerated bool `json:"enumerated"`
+	// Filter 入库时使用的过滤规则，规则变化后需要重新获取文件列表
+	Filter    string    `json:"filter,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// record JSONL 文件中的一行，后写入的记录覆盖先前的同名记录
+type record struct {
+	Job  *Job  `json:"job,omitempty"`
+	Work *Work `json:"work,omitempty"`
+}
+
+// Store 基于 JSONL 追加日志的任务库
+// 每次变更追加一行，打开时重放并压缩，进程崩溃最多丢失最后一行
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	jobs  map[string]*Job
+	works map[string]*Work
+}
+
+// Open 打开（或创建）任务库
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:  path,
+		jobs:  make(map[string]*Job),
+		works: make(map[string]*Work),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = file
+	return s, nil
+}
+
+func (s *Store) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// 崩溃时可能留下半行，跳过即可
+			continue
+		}
+		s.apply(&r)
+	}
+	return scanner.Err()
+}
+
+func (s *Store) apply(r *record) {
+	if r.Job != nil && r.Job.ID != "" {
+		s.jobs[r.Job.ID] = r.Job
+	}
+	if r.Work != nil && r.Work.RJ != "" {
+		s.works[r.Work.RJ] = r.Work
+	}
+}
+
+// compact 只保留每条记录的最新状态
+func (s *Store) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	enc := json.NewEncoder(writer)
+	for _, w := range s.works {
+		if err := enc.Encode(&record{Work: w}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	for _, j := range s.sortedJobs("") {
+		if err := enc.Encode(&record{Job: j}); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *Store) append(r *record) error {
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// Put 新增或覆盖一条文件记录
+func (s *Store) Put(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.UpdatedAt = time.Now()
+	s.jobs[job.ID] = &job
+	return s.append(&record{Job: &job})
+}
+
+// Update 修改已有的文件记录，记录不存在时忽略
+func (s *Store) Update(id string, fn func(job *Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	job := *old
+	fn(&job)
+	job.UpdatedAt = time.Now()
+	s.jobs[id] = &job
+	return s.append(&record{Job: &job})
+}
+
+// SetState 更新文件状态，失败时记录错误信息
+func (s *Store) SetState(id string, state State, err error) error {
+	return s.Update(id, func(job *Job) {
+		job.State = state
+		job.Error = ""
+		if err != nil {
+			job.Error = err.Error()
+		}
+	})
+}
+
+// Get 获取单条文件记录
+func (s *Store) Get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List 按作品列出文件记录，rj 为空时列出全部
+func (s *Store) List(rj string) []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sorted := s.sortedJobs(rj)
+	result := make([]Job, 0, len(sorted))
+	for _, j := range sorted {
+		result = append(result, *j)
+	}
+	return result
+}
+
+func (s *Store) sortedJobs(rj string) []*Job {
+	result := make([]*Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		if rj == "" || j.RJ == rj {
+			result = append(result, j)
+		}
+	}
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].ID < result[b].ID
+	})
+	return result
+}
+
+// PendingRJs 列出仍有未完成文件的作品，手动暂停或取消的文件不计入
+func (s *Store) PendingRJs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	for _, j := range s.sortedJobs("") {
+		if j.State != StateUploaded && !j.State.Stopped() && !seen[j.RJ] {
+			seen[j.RJ] = true
+			result = append(result, j.RJ)
+		}
+	}
+	return result
+}
+
+// Unfinished 统计 rjs 中已完成和未完成的文件数（手动暂停或取消的不计入），返回仍需继续的作品
+// 文件列表还没有完整入库的作品同样需要继续
+func (s *Store) Unfinished(rjs []string) (remaining []string, done, unfinished int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining = make([]string, 0, len(rjs))
+	for _, rj := range rjs {
+		w, ok := s.works[rj]
+		pending := !ok || !w.Enumerated
+		for _, j := range s.sortedJobs(rj) {
+			if j.State == StateUploaded {
+				done++
+			} else if !j.State.Stopped() {
+				unfinished++
+				pending = true
+			}
+		}
+		if pending {
+			remaining = append(remaining, rj)
+		}
+	}
+	return remaining, done, unfinished
+}
+
+// MarkEnumerated 记录作品的文件列表已按 filter 描述的过滤规则全部入库
+func (s *Store) MarkEnumerated(rj, basePath, filter string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w := &Work{RJ: rj, BasePath: basePath, Enumerated: true, Filter: filter, UpdatedAt: time.Now()}
+	s.works[rj] = w
+	return s.append(&record{Work: w})
+}
+
+// GetWork 获取作品记录
+func (s *Store) GetWork(rj string) (Work, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.works[rj]
+	if !ok {
+		return Work{}, false
+	}
+	return *w, true
+}
+
+// Enumerated 作品的文件列表是否已全部入库
+func (s *Store) Enumerated(rj string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.works[rj]
+	return ok && w.Enumerated
+}
+
+// Close 关闭任务库
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func lineCount(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := []Job{
+		{ID: "RJ123456/01.wav", RJ: "RJ123456", URL: "https://a.example/1", State: StateQueued},
+		{ID: "RJ123456/02.wav", RJ: "RJ123456", URL: "https://a.example/2", State: StateQueued},
+		{ID: "RJ234567/01.mp3", RJ: "RJ234567", URL: "https://a.example/3", State: StateQueued},
+	}
+	for _, j := range jobs {
+		if err := s.Put(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		retry := i
+		if err := s.Update("RJ123456/01.wav", func(j *Job) {
+			j.State = StateFailed
+			j.RetryCount = retry
+			j.Error = "status 503"
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetState("RJ123456/02.wav", StateUploaded, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Update("missing", func(j *Job) { j.State = StateFailed }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkEnumerated("RJ123456", "Circle/RJ123456", "prefer=wav"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lineCount(t, path); got != 8 {
+		t.Fatalf("appended %d lines, want 8", got)
+	}
+
+	// 崩溃时留下的半行
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"job":{"id":"RJ123456/01.wav","retry_count":0`)
+	f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := lineCount(t, path); got != 4 {
+		t.Errorf("compacted to %d lines, want 4", got)
+	}
+
+	failed, ok := s.Get("RJ123456/01.wav")
+	if !ok || failed.State != StateFailed || failed.RetryCount != 3 || failed.Error != "status 503" || failed.URL != "https://a.example/1" {
+		t.Errorf("failed job after reopen = %+v", failed)
+	}
+	if done, _ := s.Get("RJ123456/02.wav"); done.State != StateUploaded {
+		t.Errorf("uploaded job after reopen = %+v", done)
+	}
+	if _, ok := s.Get("missing"); ok {
+		t.Error("Update created a missing job")
+	}
+	if got := len(s.List("RJ123456")); got != 2 {
+		t.Errorf("List(RJ123456) returned %d jobs, want 2", got)
+	}
+	w, ok := s.GetWork("RJ123456")
+	if !ok || !w.Enumerated || w.BasePath != "Circle/RJ123456" || w.Filter != "prefer=wav" {
+		t.Errorf("work after reopen = %+v", w)
+	}
+	if s.Enumerated("RJ234567") {
+		t.Error("RJ234567 should not be enumerated")
+	}
+	if got := s.PendingRJs(); len(got) != 2 || got[0] != "RJ123456" || got[1] != "RJ234567" {
+		t.Errorf("PendingRJs = %v", got)
+	}
+
+	// 压缩后继续追加，再次打开时仍能读到
+	if err := s.Update("RJ123456/01.wav", func(j *Job) { j.RetryCount = 4 }); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := s.Get("RJ123456/01.wav"); j.RetryCount != 4 {
+		t.Errorf("RetryCount after second reopen = %d, want 4", j.RetryCount)
+	}
+	s.Close()
+}
+
+func TestStoreUnfinished(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "jobs.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, j := range []Job{
+		{ID: "RJ111111/01.wav", RJ: "RJ111111", State: StateUploaded},
+		{ID: "RJ111111/02.wav", RJ: "RJ111111", State: StateUploaded},
+		{ID: "RJ222222/01.wav", RJ: "RJ222222", State: StateUploaded},
+		{ID: "RJ222222/02.wav", RJ: "RJ222222", State: StateQueued},
+		{ID: "RJ222222/03.wav", RJ: "RJ222222", State: StateFailed},
+		{ID: "RJ333333/01.wav", RJ: "RJ333333", State: StatePaused},
+		{ID: "RJ333333/02.wav", RJ: "RJ333333", State: StateCanceled},
+		{ID: "RJ444444/01.wav", RJ: "RJ444444", State: StateUploaded},
+	} {
+		if err := s.Put(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rj := range []string{"RJ111111", "RJ222222", "RJ333333"} {
+		if err := s.MarkEnumerated(rj, rj, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// RJ444444 的文件列表没有入库完成，RJ555555 还没有任何记录，两者都需要继续
+	remaining, done, unfinished := s.Unfinished([]string{"RJ111111", "RJ222222", "RJ333333", "RJ444444", "RJ555555"})
+	if want := []string{"RJ222222", "RJ444444", "RJ555555"}; !reflect.DeepEqual(remaining, want) {
+		t.Errorf("remaining = %v, want %v", remaining, want)
+	}
+	if done != 4 || unfinished != 2 {
+		t.Errorf("done, unfinished = %d, %d; want 4, 2", done, unfinished)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -16,7 +18,22 @@ import (
 
 var reader = bufio.NewReader(os.Stdin)
 
+// interactive 标准输入是否为终端；非终端时绝不阻塞在 readInput 上
+var interactive = utils.IsTerminal(os.Stdin)
+
+// errDownloadIncomplete 表示有文件在达到最大重试次数后仍然下载失败
+var errDownloadIncomplete = errors.New("download incomplete")
+
 func main() {
+	// 带参数时进入命令行模式，执行完直接以退出码结束
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+	if !interactive {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+
 	utils.Success(i18n.T("welcome", i18n.AppName()))
 	utils.Info(i18n.T("config_loaded"))
 
@@ -78,11 +95,23 @@ func showMenu() {
 }
 
 func readInput(prompt string) string {
+	if !interactive {
+		return ""
+	}
 	fmt.Printf("%s: ", prompt)
 	input, _ := reader.ReadString('\n')
 	return strings.TrimSpace(input)
 }
 
+// waitForEnter 等待用户按回车返回主菜单，非交互模式下直接返回
+func waitForEnter() {
+	if !interactive {
+		return
+	}
+	utils.Info(i18n.T("press_enter_to_return"))
+	reader.ReadString('\n')
+}
+
 func startDownload() {
 	utils.Info(i18n.T("start_download_title"))
 	utils.Info(i18n.T("download_input_hint"))
@@ -94,15 +123,37 @@ func startDownload() {
 		return
 	}
 
-	tasks := strings.Split(rjNumbers, " ")
-	executeDownload(tasks)
+	// 也可以输入一个文本文件的路径，例如 DLsite 愿望单导出
+	strict := true
+	if data, err := os.ReadFile(rjNumbers); err == nil {
+		rjNumbers = string(data)
+		strict = false
+	}
+	tasks := extractTasks(rjNumbers, strict)
+	if len(tasks) == 0 {
+		utils.Warning(i18n.T("no_rj_input"))
+		return
+	}
+	utils.Info(i18n.T("rj_extracted", len(tasks)))
+	continueDownload(tasks)
 }
 
+// continueDownload 菜单模式下载，被信号中断时保存进度后直接退出
 func continueDownload(tasks []string) {
-	executeDownload(tasks)
+	if errors.Is(executeDownload(tasks), errInterrupted) {
+		os.Exit(exitInterrupted)
+	}
+}
+
+// executeDownload 执行下载并在超时时自动重启，全部成功时返回 nil
+// 收到 SIGINT/SIGTERM 时等待正在下载的分块落盘，保存进度后返回 errInterrupted
+func executeDownload(tasks []string) error {
+	ctx, stop := notifyShutdown()
+	defer stop()
+	return runDownload(ctx, tasks)
 }
 
-func executeDownload(tasks []string) {
+func runDownload(parent context.Context, tasks []string) error {
 	// 保存下载状态
 	if err := config.SaveDownloadState(spider.Conf, tasks); err != nil {
 		utils.Error(i18n.T("save_download_state_failed", err))
@@ -112,10 +163,13 @@ func executeDownload(tasks []string) {
 	utils.GlobalMonitor.Start()
 	defer utils.GlobalMonitor.Stop()
 
-	// 启动超时检测goroutine
-	stopMonitor := make(chan bool)
-	timeoutDetected := make(chan bool, 1)
+	// 启动自适应限速
+	utils.Throttle.Start()
+	defer utils.Throttle.Stop()
 
+	// 无活动超时时取消 ctx，中断所有请求、下载和缓存等待，旧的任务全部退出后再重启
+	ctx, cancel := context.WithCancel(parent)
+	stopMonitor := make(chan struct{})
 	go func() {
 		ticker := time.NewTicker(30 * time.Second) // 每30秒检查一次
 		defer ticker.Stop()
@@ -126,7 +180,7 @@ func executeDownload(tasks []string) {
 				if utils.GlobalMonitor.IsTimeout() {
 					inactiveTime := utils.GlobalMonitor.GetInactiveTime()
 					utils.Warning(i18n.T("timeout_detected", inactiveTime.Round(time.Second)))
-					timeoutDetected <- true
+					cancel()
 					return
 				}
 			case <-stopMonitor:
@@ -136,77 +190,83 @@ func executeDownload(tasks []string) {
 	}()
 
 	// 执行下载
-	success := performDownload(tasks, timeoutDetected)
+	finished, err := performDownload(ctx, tasks)
 
 	// 停止监控
 	close(stopMonitor)
+	cancel()
+
+	// 收到退出信号，只保留未完成的作品
+	if !finished && parent.Err() != nil {
+		checkpoint(tasks)
+		return errInterrupted
+	}
 
 	// 如果检测到超时，重启下载
-	if !success {
+	if !finished {
 		utils.Warning(i18n.T("download_interrupted"))
 		time.Sleep(2 * time.Second)
-		executeDownload(tasks) // 递归重启
-		return
+		return runDownload(parent, tasks) // 递归重启
+	}
+
+	// 登录失败时保留下载状态，便于之后 resume
+	if err != nil && !errors.Is(err, errDownloadIncomplete) {
+		waitForEnter()
+		return err
 	}
 
 	// 清除下载状态
-	if err := config.ClearDownloadState(spider.Conf); err != nil {
-		utils.Error(i18n.T("clear_download_state_failed", err))
+	if clearErr := config.ClearDownloadState(spider.Conf); clearErr != nil {
+		utils.Error(i18n.T("clear_download_state_failed", clearErr))
 	}
 
-	utils.Info(i18n.T("press_enter_to_return"))
-	reader.ReadString('\n')
+	waitForEnter()
+	return err
 }
 
-func performDownload(tasks []string, timeoutChan <-chan bool) bool {
+// performDownload 返回 false 表示 ctx 被取消（检测到超时或收到退出信号）
+// 返回时所有任务都已结束，不会再有 goroutine 写入临时文件
+func performDownload(ctx context.Context, tasks []string) (bool, error) {
 	// 使用配置文件中的设置
-	c := spider.NewASMRClient(spider.Conf.MaxTask, spider.Conf.MaxThread, spider.Conf.MaxRetry)
+	c := spider.NewASMRClient(ctx, spider.Conf.MaxTask, spider.Conf.MaxThread, spider.Conf.MaxRetry)
 	c.WorkerPool.Start()
+	defer c.WorkerPool.Close()
 
 	utils.Info(i18n.T("using_threads", spider.Conf.MaxThread, spider.Conf.MaxTask, spider.Conf.MaxRetry))
 
 	err := c.Login()
+	if ctx.Err() != nil {
+		return false, nil
+	}
 	if err != nil {
 		utils.Error(i18n.T("login_failed", err))
-		utils.Warning(i18n.T("press_enter_to_return"))
-		reader.ReadString('\n')
-		return true // 登录失败不算超时，返回true结束
+		return true, err // 登录失败不算超时，直接结束
 	}
 
 	for _, task := range tasks {
 		c.Download(task)
 	}
 
-	// 等待任务完成，同时监听超时信号
-	done := make(chan bool)
-	go func() {
+	// 等待任务完成，重试失败的任务，直到全部成功或达到最大重试次数
+	// ctx 被取消时正在下载的任务会很快退出，排队中的任务直接跳过
+	c.WorkerPool.Wait()
+	for c.RetryFailedTasks() {
 		c.WorkerPool.Wait()
+	}
+	if ctx.Err() != nil {
+		return false, nil
+	}
 
-		// 重试失败的任务，直到全部成功或达到最大重试次数
-		for c.RetryFailedTasks() {
-			c.WorkerPool.Wait()
+	if len(c.FailedTasks) > 0 {
+		utils.Error(i18n.T("download_failed_count", len(c.FailedTasks)))
+		utils.Info(i18n.T("failed_files_list"))
+		for _, task := range c.FailedTasks {
+			utils.Error("  - %s", task.FileName)
 		}
-		done <- true
-	}()
-
-	// 等待完成或超时
-	select {
-	case <-done:
-		// 正常完成
-		if len(c.FailedTasks) > 0 {
-			utils.Error(i18n.T("download_failed_count", len(c.FailedTasks)))
-			utils.Info(i18n.T("failed_files_list"))
-			for _, task := range c.FailedTasks {
-				utils.Error("  - %s", task.FileName)
-			}
-		} else {
-			utils.Success(i18n.T("download_complete"))
-		}
-		return true
-	case <-timeoutChan:
-		// 检测到超时
-		return false
+		return true, errDownloadIncomplete
 	}
+	utils.Success(i18n.T("download_complete"))
+	return true, nil
 }
 
 func modifyConfig() {
@@ -352,6 +412,5 @@ func showAbout() {
 		utils.Info(line)
 	}
 
-	utils.Info(i18n.T("press_enter_to_return"))
-	reader.ReadString('\n')
+	waitForEnter()
 }
@@ -0,0 +1,497 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/jobs"
+	"re-asmr-spider/spider"
+	"re-asmr-spider/utils"
+	"re-asmr-spider/version"
+)
+
+// webFS 内嵌的网页面板
+//
+//go:embed web
+var webFS embed.FS
+
+const (
+	// retryInterval 队列空闲时重试失败任务的间隔
+	retryInterval = 30 * time.Second
+	// shutdownTimeout 退出时等待正在处理的请求的时间
+	shutdownTimeout = 5 * time.Second
+)
+
+// Server 守护进程模式的本地 HTTP API，与命令行共用 ASMRClient 和 WorkerPool
+type Server struct {
+	client *spider.ASMRClient
+	token  string
+
+	// queue 按顺序执行的入队操作，Download 在队列已满时会阻塞，不能放在请求处理中
+	mu    sync.Mutex
+	queue []func()
+	wake  chan struct{}
+	// pending 等待获取文件列表的作品
+	pending []string
+
+	// bwDownload/bwUpload 当前的带宽限制
+	bwDownload string
+	bwUpload   string
+}
+
+// New 创建 API 服务，client 的 WorkerPool 需已启动并完成登录
+func New(client *spider.ASMRClient, token string) *Server {
+	return &Server{
+		client:     client,
+		token:      token,
+		wake:       make(chan struct{}, 1),
+		bwDownload: spider.Conf.Bandwidth.Download,
+		bwUpload:   spider.Conf.Bandwidth.Upload,
+	}
+}
+
+// ListenAndServe 启动入队与重试循环并监听 addr
+// ctx 结束时停止接受请求，等待正在执行的入队操作返回后返回 nil，此后不会再提交新任务
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	if s.token == "" && !isLoopback(addr) {
+		utils.Warning(i18n.T("daemon_no_token", addr))
+	}
+	srv := &http.Server{Addr: addr, Handler: s.Handler()}
+	queueDone := make(chan struct{})
+	go func() {
+		s.runQueue(ctx)
+		close(queueDone)
+	}()
+	go s.retryLoop(ctx)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	utils.Success(i18n.T("daemon_listening", addr))
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-queueDone
+	return nil
+}
+
+// Handler 网页面板和所有 API 路由，token 非空时 API 需先校验
+// 面板本身是静态页面，不需要 token，由页面在请求 API 时带上
+func (s *Server) Handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("/api/status", s.handleStatus)
+	api.HandleFunc("/api/works", s.handleWorks)
+	api.HandleFunc("/api/jobs", s.handleJobs)
+	api.HandleFunc("/api/jobs/", s.handleJobAction)
+	api.HandleFunc("/api/failures", s.handleFailures)
+	api.HandleFunc("/api/limits", s.handleLimits)
+	api.HandleFunc("/api/cache", s.handleCache)
+	api.HandleFunc("/metrics", s.handleMetrics)
+
+	web, _ := fs.Sub(webFS, "web")
+	mux := http.NewServeMux()
+	// 面板文字在输入 token 之前就需要显示，不做校验
+	mux.HandleFunc("/api/i18n", s.handleI18n)
+	mux.Handle("/api/", s.auth(api))
+	mux.Handle("/metrics", s.auth(api))
+	mux.Handle("/", http.FileServer(http.FS(web)))
+	return mux
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enqueue 加入入队队列，立即返回
+func (s *Server) enqueue(fn func()) {
+	s.mu.Lock()
+	s.queue = append(s.queue, fn)
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runQueue ctx 结束后丢弃尚未执行的入队操作并返回
+func (s *Server) runQueue(ctx context.Context) {
+	for {
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+		for ctx.Err() == nil {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			fn := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			fn()
+		}
+	}
+}
+
+// retryLoop 队列空闲时重试失败的任务，与命令行模式的重试轮次相同
+// 只剩达到最大重试次数的任务时不再发起新的轮次
+func (s *Server) retryLoop(ctx context.Context) {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if len(s.client.WorkerPool.Active()) == 0 && s.client.Retryable() {
+			s.enqueue(func() { s.client.RetryFailedTasks() })
+		}
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	counts := make(map[jobs.State]int)
+	for _, job := range spider.Jobs.List("") {
+		counts[job.State]++
+	}
+	s.mu.Lock()
+	pending := append([]string{}, s.pending...)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"version":  version.GetFullVersion(),
+		"running":  s.client.WorkerPool.Running(),
+		"active":   s.client.WorkerPool.Active(),
+		"pending":  pending,
+		"failures": len(s.client.Failures()),
+		"jobs":     counts,
+	})
+}
+
+// handleWorks POST {"rj": ["RJ123456", "https://asmr.one/work/RJ..."], "text": "..."} 加入作品
+func (s *Server) handleWorks(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		RJ   []string `json:"rj"`
+		Text string   `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	res := spider.ExtractRJ(strings.Join(req.RJ, "\n") + "\n" + req.Text)
+	if len(res.RJs) == 0 {
+		writeJSON(w, http.StatusBadRequest, res)
+		return
+	}
+	for _, rj := range res.RJs {
+		rj := rj
+		s.mu.Lock()
+		s.pending = append(s.pending, rj)
+		s.mu.Unlock()
+		s.enqueue(func() {
+			s.client.Download(rj)
+			s.mu.Lock()
+			for i, p := range s.pending {
+				if p == rj {
+					s.pending = append(s.pending[:i], s.pending[i+1:]...)
+					break
+				}
+			}
+			s.mu.Unlock()
+		})
+	}
+	utils.Info(i18n.T("daemon_enqueued", strings.Join(res.RJs, ", ")))
+	writeJSON(w, http.StatusAccepted, res)
+}
+
+// handleJobs GET ?rj=RJ123456&state=failed 列出文件记录
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	rj := r.URL.Query().Get("rj")
+	if rj != "" {
+		normalized, err := spider.NormalizeRJ(rj)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		rj = normalized
+	}
+	state := jobs.State(r.URL.Query().Get("state"))
+	list := make([]jobs.Job, 0)
+	for _, job := range spider.Jobs.List(rj) {
+		if state == "" || job.State == state {
+			list = append(list, job)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleJobAction POST /api/jobs/{pause|resume|cancel} {"id": "RJ123456/mp3/01.mp3"}
+// id 为 jobs 中的 id（文件的最终路径），包含 / 所以放在请求体中
+func (s *Server) handleJobAction(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var err error
+	switch strings.TrimPrefix(r.URL.Path, "/api/jobs/") {
+	case "pause":
+		err = s.client.PauseJob(req.ID)
+	case "cancel":
+		err = s.client.CancelJob(req.ID)
+	case "resume":
+		// 先检查再入队，ResumeJob 在队列已满时会阻塞
+		if err = checkResumable(req.ID); err == nil {
+			id := req.ID
+			s.enqueue(func() {
+				if err := s.client.ResumeJob(id); err != nil {
+					utils.Warning(i18n.T("job_store_error", err))
+				}
+			})
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case errors.Is(err, spider.ErrJobNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, spider.ErrJobDone), errors.Is(err, spider.ErrJobActive):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"id": req.ID})
+	}
+}
+
+func checkResumable(id string) error {
+	job, ok := spider.Jobs.Get(id)
+	if !ok {
+		return spider.ErrJobNotFound
+	}
+	if job.State == jobs.StateUploaded {
+		return spider.ErrJobDone
+	}
+	return nil
+}
+
+// handleFailures 等待重试的任务和任务库中失败的文件
+func (s *Server) handleFailures(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	failed := make([]jobs.Job, 0)
+	for _, job := range spider.Jobs.List("") {
+		if job.State == jobs.StateFailed {
+			failed = append(failed, job)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"retrying": s.client.Failures(),
+		"jobs":     failed,
+	})
+}
+
+// handleCache rclone VFS 缓存占用和流控阈值，供面板绘制曲线
+// 不写入挂载点、流控关闭或 rclone RC 无法连接时返回 enabled=false，面板隐藏缓存曲线
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if _, isMount := spider.Storage.(*utils.MountStorage); !isMount {
+		writeJSON(w, http.StatusOK, &utils.CacheStatus{})
+		return
+	}
+	status, err := utils.GetCacheStatus(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false, "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleI18n GET ?lang=en-US 面板使用的文字，lang 为空或不支持时使用配置中的语言
+func (s *Server) handleI18n(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	lang := r.URL.Query().Get("lang")
+	if i18n.GetLanguageByCode(lang) == nil {
+		lang = i18n.GetLocale()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"lang":     lang,
+		"messages": i18n.Messages(lang, "web_"),
+	})
+}
+
+// handleMetrics Prometheus 文本格式的指标，设置了 token 时抓取配置需带 bearer_token
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	utils.WriteMetrics(r.Context(), w, s.client.WorkerPool)
+
+	mw := utils.MetricWriter{W: w}
+	s.mu.Lock()
+	pending := len(s.pending)
+	s.mu.Unlock()
+	mw.Metric("asmr_pending_works", "gauge", "Works waiting for their file list.", float64(pending))
+	mw.Metric("asmr_retry_queue", "gauge", "Failed files waiting for the next retry round.", float64(len(s.client.Failures())))
+
+	counts := make(map[jobs.State]int)
+	for _, job := range spider.Jobs.List("") {
+		counts[job.State]++
+	}
+	mw.Header("asmr_jobs", "gauge", "Files in the job store by state.")
+	for _, state := range []jobs.State{jobs.StateQueued, jobs.StateDownloading, jobs.StateStaged, jobs.StateUploaded, jobs.StateFailed, jobs.StatePaused, jobs.StateCanceled} {
+		mw.Sample("asmr_jobs", float64(counts[state]), "state", string(state))
+	}
+}
+
+// limits GET/PUT /api/limits 的内容，PUT 时只修改给出的字段，只在本次运行中生效
+type limits struct {
+	MaxTask   *int    `json:"max_task,omitempty"`
+	MaxThread *int    `json:"max_thread,omitempty"`
+	Download  *string `json:"bwlimit_download,omitempty"`
+	Upload    *string `json:"bwlimit_upload,omitempty"`
+}
+
+func (s *Server) handleLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req limits
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := s.applyLimits(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPut)
+		return
+	}
+
+	maxTask, maxThread := s.client.WorkerPool.GetLimit(), s.client.Threads()
+	s.mu.Lock()
+	download, upload := s.bwDownload, s.bwUpload
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, limits{MaxTask: &maxTask, MaxThread: &maxThread, Download: &download, Upload: &upload})
+}
+
+// applyLimits 先检查全部字段，全部合法后再修改
+func (s *Server) applyLimits(req *limits) error {
+	if req.MaxTask != nil && *req.MaxTask <= 0 {
+		return errors.New("max_task must be greater than 0")
+	}
+	if req.MaxThread != nil && *req.MaxThread <= 0 {
+		return errors.New("max_thread must be greater than 0")
+	}
+	for _, spec := range []*string{req.Download, req.Upload} {
+		if spec != nil {
+			if _, err := utils.NewBandwidthLimiter(*spec); err != nil {
+				return err
+			}
+		}
+	}
+
+	if req.MaxTask != nil {
+		s.client.WorkerPool.SetLimit(*req.MaxTask)
+	}
+	if req.MaxThread != nil {
+		s.client.SetThreads(*req.MaxThread)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Download != nil {
+		_ = utils.DownloadLimiter.SetSchedule(*req.Download)
+		s.bwDownload = *req.Download
+	}
+	if req.Upload != nil {
+		_ = utils.UploadLimiter.SetSchedule(*req.Upload)
+		s.bwUpload = *req.Upload
+	}
+	utils.Info(i18n.T("daemon_limits_changed"))
+	return nil
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// isLoopback 监听地址是否只对本机开放
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"re-asmr-spider/config"
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/spider"
+	"re-asmr-spider/utils"
+)
+
+// errInterrupted 下载被 SIGINT/SIGTERM 中断，进度已保存
+var errInterrupted = errors.New("interrupted by signal")
+
+// notifyShutdown 第一次收到 SIGINT/SIGTERM 时取消 ctx：不再接受新任务，正在下载的分块落盘并保存进度
+// 第二次收到信号时立即退出；stop 恢复默认的信号处理
+func notifyShutdown() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			utils.Warning(i18n.T("shutdown_requested", sig))
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-sigs:
+			utils.Error(i18n.T("shutdown_forced"))
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		close(done)
+		cancel()
+	}
+}
+
+// checkpoint 中断后只把仍有未完成文件的作品写回下载状态，并打印本次的完成情况
+// 文件级别的进度已在任务库和 .progress 进度日志中
+func checkpoint(tasks []string) {
+	remaining, done, unfinished := spider.Jobs.Unfinished(tasks)
+
+	var err error
+	if len(remaining) > 0 {
+		err = config.SaveDownloadState(spider.Conf, remaining)
+	} else {
+		err = config.ClearDownloadState(spider.Conf)
+	}
+	if err != nil {
+		utils.Error(i18n.T("save_download_state_failed", err))
+	}
+	utils.Warning(i18n.T("shutdown_summary", done, unfinished, len(remaining)))
+}
@@ -0,0 +1,300 @@
+package spider
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/utils"
+)
+
+// TokenCacheFile 登录 token 缓存，避免每次运行都重新登录
+const TokenCacheFile = "token.json"
+
+// token 剩余有效期不足该时长时重新登录
+const tokenRefreshMargin = time.Hour
+
+var (
+	// ErrBadCredentials 账号或密码错误
+	ErrBadCredentials = errors.New("invalid account or password")
+	// ErrCloudflare 请求被 Cloudflare 拦截（返回了验证页面而不是 JSON）
+	ErrCloudflare = errors.New("blocked by cloudflare challenge")
+	// ErrUnauthorized token 无效或已过期
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// tokenCache 磁盘上的 token 缓存
+type tokenCache struct {
+	Account   string    `json:"account"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// GuestMode 账号为空时不登录，以游客身份访问接口
+func GuestMode() bool {
+	return strings.TrimSpace(Conf.Account) == ""
+}
+
+// Login 登录并设置 Authorization，优先使用未过期的缓存 token
+// 登录前探测所有接口和下载镜像，之后的请求从可用的镜像开始
+func (ac *ASMRClient) Login() error {
+	if hosts := APIHosts.Hosts(); len(hosts) > 1 {
+		APIHosts.Probe(ac.ctx, "/api/health")
+		utils.Info(i18n.T("api_host_selected", APIHosts.Hosts()[0]))
+	}
+	if hosts := MediaHosts.Hosts(); MediaHosts != APIHosts && len(hosts) > 1 {
+		MediaHosts.Probe(ac.ctx, "/")
+		utils.Info(i18n.T("media_host_selected", MediaHosts.Hosts()[0]))
+	}
+
+	ac.authMu.Lock()
+	defer ac.authMu.Unlock()
+	return ac.login(true)
+}
+
+func (ac *ASMRClient) login(useCache bool) error {
+	utils.GlobalMonitor.UpdateActivity()
+
+	if GuestMode() {
+		ac.Authorization = ""
+		utils.Info(i18n.T("login_guest"))
+		return nil
+	}
+
+	if useCache {
+		if cache, ok := loadTokenCache(); ok {
+			ac.Authorization = "Bearer " + cache.Token
+			utils.Success(i18n.T("login_cached"))
+			return nil
+		}
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"name":     Conf.Account,
+		"password": Conf.Password,
+	})
+	if err != nil {
+		utils.Error(i18n.T("parse_error", err))
+		return err
+	}
+	var all []byte
+	err = APIHosts.Do(func(base string) error {
+		client := utils.Client.Get().(*http.Client)
+		req, _ := http.NewRequestWithContext(ac.ctx, "POST", base+"/api/auth/me", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Referer", "https://www.asmr.one/")
+		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36")
+		resp, err := client.Do(req)
+		utils.Client.Put(client)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+		all, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return checkResponse(resp, all)
+	})
+	if err != nil {
+		// 登录接口的 401/403 表示账号密码错误
+		if errors.Is(err, ErrUnauthorized) {
+			err = fmt.Errorf("%w: %s", ErrBadCredentials, apiErrorMessage(all))
+		}
+		if errors.Is(err, ErrCloudflare) {
+			utils.Warning(i18n.T("cloudflare_hint"))
+		}
+		return err
+	}
+
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(all, &res); err != nil {
+		return fmt.Errorf("invalid login response: %v", err)
+	}
+	if res.Token == "" {
+		return fmt.Errorf("%w: empty token", ErrBadCredentials)
+	}
+
+	ac.Authorization = "Bearer " + res.Token
+	if err := saveTokenCache(res.Token); err != nil {
+		utils.Warning(i18n.T("token_cache_failed", err))
+	}
+	utils.GlobalMonitor.UpdateActivity()
+	utils.Success(i18n.T("login_success"))
+	return nil
+}
+
+// relogin token 失效时重新登录，其他请求已经刷新过 token 时直接返回
+func (ac *ASMRClient) relogin(staleAuth string) error {
+	ac.authMu.Lock()
+	defer ac.authMu.Unlock()
+	if ac.Authorization != staleAuth {
+		return nil
+	}
+	utils.Warning(i18n.T("login_expired"))
+	_ = os.Remove(TokenCacheFile)
+	return ac.login(false)
+}
+
+// apiGet 请求 asmr.one 接口，返回 401 时重新登录并重试一次
+func (ac *ASMRClient) apiGet(path string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		ac.authMu.Lock()
+		auth := ac.Authorization
+		ac.authMu.Unlock()
+
+		body, err := doAPIGet(ac.ctx, path, auth)
+		if errors.Is(err, ErrUnauthorized) && attempt == 0 && !GuestMode() {
+			if err := ac.relogin(auth); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return body, err
+	}
+}
+
+func doAPIGet(ctx context.Context, path, auth string) ([]byte, error) {
+	utils.GlobalMonitor.UpdateActivity()
+
+	var all []byte
+	err := APIHosts.Do(func(base string) error {
+		client := utils.Client.Get().(*http.Client)
+		req, _ := http.NewRequestWithContext(ctx, "GET", base+path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		req.Header.Set("Referer", "https://www.asmr.one/")
+		req.Header.Set("User-Agent", "PostmanRuntime/7.29.0")
+		resp, err := client.Do(req)
+		utils.Client.Put(client)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+		all, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return checkResponse(resp, all)
+	})
+	if err != nil {
+		return nil, err
+	}
+	utils.GlobalMonitor.UpdateActivity()
+	return all, nil
+}
+
+// checkResponse 区分 Cloudflare 拦截、未授权和其他错误状态
+func checkResponse(resp *http.Response, body []byte) error {
+	if isCloudflareChallenge(resp, body) {
+		return ErrCloudflare
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrUnauthorized, apiErrorMessage(body))
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d: %s", ErrServerError, resp.StatusCode, apiErrorMessage(body))
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("status %d: %s", resp.StatusCode, apiErrorMessage(body))
+	}
+	return nil
+}
+
+// isCloudflareChallenge Cloudflare 的验证页是 HTML，且带有 cf-ray 或 cf-mitigated 头
+func isCloudflareChallenge(resp *http.Response, body []byte) bool {
+	if resp.Header.Get("Cf-Mitigated") == "challenge" {
+		return true
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "text/html") {
+		return false
+	}
+	if resp.Header.Get("Cf-Ray") == "" && !strings.EqualFold(resp.Header.Get("Server"), "cloudflare") {
+		return false
+	}
+	text := string(body)
+	return strings.Contains(text, "Just a moment") || strings.Contains(text, "cf-chl") ||
+		strings.Contains(text, "Attention Required")
+}
+
+// apiErrorMessage 提取接口返回的错误信息，格式为 {"error": "..."} 或 {"errors": [{"msg": "..."}]}
+func apiErrorMessage(body []byte) string {
+	var res struct {
+		Error  string `json:"error"`
+		Errors []struct {
+			Msg string `json:"msg"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &res) == nil {
+		if res.Error != "" {
+			return res.Error
+		}
+		if len(res.Errors) > 0 && res.Errors[0].Msg != "" {
+			return res.Errors[0].Msg
+		}
+	}
+	text := strings.TrimSpace(string(body))
+	if len(text) > 200 {
+		text = text[:200] + "..."
+	}
+	return text
+}
+
+// tokenExpiry 解析 JWT 中的 exp，无法解析时返回零值
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// loadTokenCache 读取当前账号仍然有效的缓存 token
+func loadTokenCache() (*tokenCache, bool) {
+	data, err := os.ReadFile(TokenCacheFile)
+	if err != nil {
+		return nil, false
+	}
+	var cache tokenCache
+	if json.Unmarshal(data, &cache) != nil || cache.Token == "" || cache.Account != Conf.Account {
+		return nil, false
+	}
+	// 没有过期时间的 token 直接使用，失效时由 401 触发重新登录
+	if !cache.ExpiresAt.IsZero() && time.Until(cache.ExpiresAt) < tokenRefreshMargin {
+		return nil, false
+	}
+	return &cache, true
+}
+
+func saveTokenCache(token string) error {
+	data, err := json.MarshalIndent(&tokenCache{
+		Account:   Conf.Account,
+		Token:     token,
+		ExpiresAt: tokenExpiry(token),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(TokenCacheFile, data, 0600)
+}
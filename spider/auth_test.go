@@ -0,0 +1,139 @@
+package spider
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func response(code int, headers ...string) *http.Response {
+	resp := &http.Response{StatusCode: code, Header: make(http.Header)}
+	for i := 0; i+1 < len(headers); i += 2 {
+		resp.Header.Set(headers[i], headers[i+1])
+	}
+	return resp
+}
+
+func jwt(payload string) string {
+	return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestCheckResponse(t *testing.T) {
+	challenge := []byte("<html><title>Just a moment...</title></html>")
+	tests := []struct {
+		name    string
+		resp    *http.Response
+		body    string
+		wantErr error
+		wantMsg string
+	}{
+		{"ok", response(200), `{"id":1}`, nil, ""},
+		{"unauthorized", response(401), `{"error":"bad token"}`, ErrUnauthorized, "bad token"},
+		{"forbidden with errors list", response(403), `{"errors":[{"msg":"wrong password"}]}`, ErrUnauthorized, "wrong password"},
+		{"server error", response(502), "bad gateway", ErrServerError, "status 502"},
+		{"rate limited", response(429), "", ErrServerError, "status 429"},
+		{"cloudflare challenge", response(403, "Content-Type", "text/html", "Cf-Ray", "abc"), string(challenge), ErrCloudflare, ""},
+		{"cloudflare mitigated header", response(200, "Cf-Mitigated", "challenge"), "", ErrCloudflare, ""},
+		{"not found", response(404), `{"error":"not found"}`, nil, "status 404: not found"},
+	}
+	for _, tt := range tests {
+		err := checkResponse(tt.resp, []byte(tt.body))
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: checkResponse = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr == nil && tt.wantMsg == "" && err != nil {
+			t.Errorf("%s: checkResponse = %v, want nil", tt.name, err)
+			continue
+		}
+		if tt.wantMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.wantMsg)) {
+			t.Errorf("%s: checkResponse = %v, want message containing %q", tt.name, err, tt.wantMsg)
+		}
+	}
+}
+
+func TestIsCloudflareChallenge(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *http.Response
+		body string
+		want bool
+	}{
+		{"mitigated header", response(403, "Cf-Mitigated", "challenge"), "", true},
+		{"challenge page with cf-ray", response(503, "Content-Type", "text/html; charset=UTF-8", "Cf-Ray", "1"), "<title>Just a moment...</title>", true},
+		{"block page from cloudflare server", response(403, "Content-Type", "text/html", "Server", "Cloudflare"), "Attention Required! | Cloudflare", true},
+		{"cf-chl script", response(403, "Content-Type", "text/html", "Cf-Ray", "1"), `<script src="/cdn-cgi/challenge-platform/cf-chl"></script>`, true},
+		{"json through cloudflare", response(401, "Content-Type", "application/json", "Cf-Ray", "1"), `{"error":"Just a moment"}`, false},
+		{"html without cloudflare headers", response(502, "Content-Type", "text/html"), "Just a moment", false},
+		{"ordinary error page", response(502, "Content-Type", "text/html", "Cf-Ray", "1"), "<h1>502 Bad Gateway</h1>", false},
+	}
+	for _, tt := range tests {
+		if got := isCloudflareChallenge(tt.resp, []byte(tt.body)); got != tt.want {
+			t.Errorf("%s: isCloudflareChallenge = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+	}{
+		{"exp", jwt(`{"sub":"u","exp":1700000000}`), time.Unix(1700000000, 0)},
+		{"padded payload", strings.Replace(jwt(`{"exp":1700000000}`), ".sig", "==.sig", 1), time.Unix(1700000000, 0)},
+		{"no exp", jwt(`{"sub":"u"}`), time.Time{}},
+		{"bad json", jwt(`not json`), time.Time{}},
+		{"bad base64", "a.!!!.c", time.Time{}},
+		{"not a jwt", "opaque-token", time.Time{}},
+		{"empty", "", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := tokenExpiry(tt.token); !got.Equal(tt.want) {
+			t.Errorf("%s: tokenExpiry = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	account := Conf.Account
+	defer func() {
+		Conf.Account = account
+		_ = os.Remove(TokenCacheFile)
+	}()
+	Conf.Account = "user"
+
+	tests := []struct {
+		name    string
+		token   string
+		account string
+		want    bool
+	}{
+		{"valid", jwt(`{"exp":` + unix(24*time.Hour) + `}`), "user", true},
+		{"no expiry", "opaque-token", "user", true},
+		{"expires soon", jwt(`{"exp":` + unix(tokenRefreshMargin/2) + `}`), "user", false},
+		{"expired", jwt(`{"exp":` + unix(-time.Hour) + `}`), "user", false},
+		{"other account", jwt(`{"exp":` + unix(24*time.Hour) + `}`), "other", false},
+	}
+	for _, tt := range tests {
+		Conf.Account = "user"
+		if err := saveTokenCache(tt.token); err != nil {
+			t.Fatal(err)
+		}
+		Conf.Account = tt.account
+		cache, ok := loadTokenCache()
+		if ok != tt.want || (ok && cache.Token != tt.token) {
+			t.Errorf("%s: loadTokenCache = %+v, %v; want %v", tt.name, cache, ok, tt.want)
+		}
+	}
+}
+
+// unix 当前时间加 d 的 Unix 时间戳
+func unix(d time.Duration) string {
+	return strconv.FormatInt(time.Now().Add(d).Unix(), 10)
+}
@@ -0,0 +1,116 @@
+package spider
+
+import (
+	"errors"
+
+	"re-asmr-spider/jobs"
+	"re-asmr-spider/utils"
+)
+
+var (
+	// ErrJobNotFound 任务库中没有该文件
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobDone 文件已经下载完成
+	ErrJobDone = errors.New("job already uploaded")
+	// ErrJobActive 文件正在队列中或正在下载
+	ErrJobActive = errors.New("job is already queued")
+)
+
+// Threads 新任务使用的下载线程数
+func (ac *ASMRClient) Threads() int {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.ThreadCount
+}
+
+// SetThreads 修改新任务的下载线程数，正在下载的任务不受影响
+func (ac *ASMRClient) SetThreads(n int) {
+	if n <= 0 {
+		return
+	}
+	ac.mu.Lock()
+	ac.ThreadCount = n
+	ac.mu.Unlock()
+}
+
+// Failures 达到最大重试次数或等待重试的失败任务
+func (ac *ASMRClient) Failures() []FailedTask {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	tasks := make([]FailedTask, len(ac.FailedTasks))
+	copy(tasks, ac.FailedTasks)
+	return tasks
+}
+
+// Retryable 是否有尚未达到最大重试次数的失败任务
+func (ac *ASMRClient) Retryable() bool {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	for _, task := range ac.FailedTasks {
+		if task.RetryCount < ac.MaxRetry {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFailed 从重试队列中移除，id 为文件的最终路径
+func (ac *ASMRClient) removeFailed(id string) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	kept := ac.FailedTasks[:0]
+	for _, task := range ac.FailedTasks {
+		if task.DirPath+"/"+task.FileName != id {
+			kept = append(kept, task)
+		}
+	}
+	ac.FailedTasks = kept
+}
+
+// PauseJob 暂停文件下载，保留续传进度，之后用 ResumeJob 继续
+func (ac *ASMRClient) PauseJob(id string) error {
+	return ac.stopJob(id, utils.ErrPaused, jobs.StatePaused)
+}
+
+// CancelJob 取消文件下载并删除临时文件，resume 时不再自动恢复
+func (ac *ASMRClient) CancelJob(id string) error {
+	return ac.stopJob(id, utils.ErrCanceled, jobs.StateCanceled)
+}
+
+func (ac *ASMRClient) stopJob(id string, reason error, state jobs.State) error {
+	job, ok := Jobs.Get(id)
+	if !ok {
+		return ErrJobNotFound
+	}
+	if job.State == jobs.StateUploaded {
+		return ErrJobDone
+	}
+	ac.removeFailed(id)
+	// 队列中或正在下载的任务由 WorkerPool 中断，状态在 OnFailure 中更新
+	if ac.WorkerPool.Stop(id, reason) {
+		return nil
+	}
+	if state == jobs.StateCanceled && job.TempPath != "" {
+		utils.RemovePartial(job.TempPath)
+	}
+	return Jobs.SetState(id, state, nil)
+}
+
+// ResumeJob 将暂停、取消或失败的文件重新加入队列，队列已满时阻塞
+func (ac *ASMRClient) ResumeJob(id string) error {
+	job, ok := Jobs.Get(id)
+	if !ok {
+		return ErrJobNotFound
+	}
+	if job.State == jobs.StateUploaded {
+		return ErrJobDone
+	}
+	for _, a := range ac.WorkerPool.Active() {
+		if a.ID == id {
+			return ErrJobActive
+		}
+	}
+	ac.removeFailed(id)
+	ac.downloadFileInternal(job.RJ, job.URL, job.StreamURL, job.DirPath, job.FileName, 0)
+	return nil
+}
@@ -0,0 +1,176 @@
+package spider
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"re-asmr-spider/config"
+	"re-asmr-spider/utils"
+)
+
+// Filter 遍历文件列表时决定哪些文件需要下载
+type Filter struct {
+	includeExt     map[string]bool
+	excludeExt     map[string]bool
+	excludeFolders []string
+	maxSize        int64
+	// picker 为空时不做格式去重
+	picker *formatPicker
+}
+
+// NewFilter 解析过滤规则，扩展名和文件夹名均不区分大小写
+func NewFilter(cfg config.FilterConfig) (*Filter, error) {
+	f := &Filter{
+		includeExt: extSet(cfg.IncludeExt),
+		excludeExt: extSet(cfg.ExcludeExt),
+	}
+	for _, pattern := range cfg.ExcludeFolders {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, err
+			}
+			f.excludeFolders = append(f.excludeFolders, strings.ToLower(pattern))
+		}
+	}
+	if len(cfg.PreferFormats) > 0 {
+		f.picker = newFormatPicker(cfg.PreferFormats)
+	}
+	if strings.TrimSpace(cfg.MaxSize) != "" {
+		size, err := utils.ParseSize(cfg.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		f.maxSize = size
+	}
+	return f, nil
+}
+
+func extSet(exts []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			set[ext] = true
+		}
+	}
+	return set
+}
+
+// PreferFormats 同一内容有多种格式时只保留偏好的格式，返回去重后的列表和去掉的文件数
+func (f *Filter) PreferFormats(tracks []track) ([]track, int) {
+	if f.picker == nil {
+		return tracks, 0
+	}
+	picked := f.picker.pick(tracks)
+	return picked, countFiles(tracks) - countFiles(picked)
+}
+
+// countFiles 统计文件数（不含文件夹）
+func countFiles(tracks []track) int {
+	count := 0
+	for _, t := range tracks {
+		if t.Type == "folder" {
+			count += countFiles(t.Children)
+		} else {
+			count++
+		}
+	}
+	return count
+}
+
+// SkipFolder 文件夹是否整个跳过
+func (f *Filter) SkipFolder(name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range f.excludeFolders {
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		} else if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipExt 按扩展名判断是否跳过
+func (f *Filter) SkipExt(name string) bool {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if len(f.includeExt) > 0 && !f.includeExt[ext] {
+		return true
+	}
+	return f.excludeExt[ext]
+}
+
+// SkipSize 按大小判断是否跳过，大小未知（0）时不跳过
+func (f *Filter) SkipSize(size int64) bool {
+	return f.maxSize > 0 && size > f.maxSize
+}
+
+// LimitsSize 是否设置了大小上限，未设置时无需获取文件大小
+func (f *Filter) LimitsSize() bool {
+	return f.maxSize > 0
+}
+
+// 命令行临时指定的过滤规则，优先于配置文件
+var (
+	filterOverrides   = make(map[string]config.FilterConfig)
+	filterOverridesMu sync.Mutex
+)
+
+// SetFilterOverride 为单个作品指定过滤规则（只在本次运行中生效）
+func SetFilterOverride(rj string, cfg config.FilterConfig) {
+	filterOverridesMu.Lock()
+	defer filterOverridesMu.Unlock()
+	filterOverrides[rj] = cfg
+}
+
+// filterConfigFor 依次使用命令行、filter_overrides 和全局 filter 中的规则，cli 表示本次运行在命令行中指定了规则
+func filterConfigFor(rj string) (cfg config.FilterConfig, cli bool) {
+	filterOverridesMu.Lock()
+	cfg, cli = filterOverrides[rj]
+	filterOverridesMu.Unlock()
+	if cli {
+		return cfg, true
+	}
+	if cfg, ok := Conf.FilterOverrides[rj]; ok {
+		return cfg, false
+	}
+	return Conf.Filter, false
+}
+
+// filterFor 返回作品当前生效的过滤规则
+func filterFor(rj string) (*Filter, error) {
+	cfg, _ := filterConfigFor(rj)
+	return NewFilter(cfg)
+}
+
+// filterKey 过滤规则的规范化表示，记录在任务库中，规则变化后需要重新获取文件列表
+// 扩展名不区分顺序和大小写，格式偏好保留顺序
+func filterKey(cfg config.FilterConfig) string {
+	normalize := func(items []string, ext bool, sorted bool) string {
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			item = strings.ToLower(strings.TrimSpace(item))
+			if ext {
+				item = strings.TrimPrefix(item, ".")
+			}
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+		if sorted {
+			sort.Strings(list)
+		}
+		return strings.Join(list, ",")
+	}
+	return strings.Join([]string{
+		"include=" + normalize(cfg.IncludeExt, true, true),
+		"exclude=" + normalize(cfg.ExcludeExt, true, true),
+		"folders=" + normalize(cfg.ExcludeFolders, false, true),
+		"max=" + strings.ToUpper(strings.TrimSpace(cfg.MaxSize)),
+		"prefer=" + normalize(cfg.PreferFormats, true, false),
+	}, ";")
+}
@@ -0,0 +1,227 @@
+package spider
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// 参与格式比较的音频扩展名，按固定顺序从标题中去掉格式字样
+var audioFormats = []string{"flac", "wav", "mp3", "m4a", "aac", "ogg", "opus", "ape", "wma", "aiff"}
+
+var audioExts = func() map[string]bool {
+	exts := make(map[string]bool, len(audioFormats))
+	for _, ext := range audioFormats {
+		exts[ext] = true
+	}
+	return exts
+}()
+
+// formatPicker 在同级文件夹（以及同一文件夹）中只保留偏好顺序最靠前的格式
+// 例如 wav/01.wav 与 mp3/01.mp3 按去掉扩展名和格式字样后的标题匹配，偏好 flac > wav > mp3 时只下载 wav
+type formatPicker struct {
+	rank map[string]int
+}
+
+func newFormatPicker(prefs []string) *formatPicker {
+	p := &formatPicker{rank: make(map[string]int)}
+	for i, ext := range prefs {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if _, ok := p.rank[ext]; ext != "" && !ok {
+			p.rank[ext] = i
+		}
+	}
+	return p
+}
+
+// rankOf 偏好列表之外的格式排在最后
+func (p *formatPicker) rankOf(format string) int {
+	if r, ok := p.rank[format]; ok {
+		return r
+	}
+	return len(p.rank)
+}
+
+func audioExt(name string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	if audioExts[ext] {
+		return ext
+	}
+	return ""
+}
+
+// normalizeTitle 去掉扩展名、格式字样和标点，只保留字母数字用于匹配
+func normalizeTitle(name string) string {
+	name = strings.ToLower(name)
+	if ext := audioExt(name); ext != "" {
+		name = strings.TrimSuffix(name, "."+ext)
+	}
+	for _, format := range audioFormats {
+		name = stripToken(name, format)
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// stripToken 去掉独立出现的 token，前后紧挨英文字母时视为单词的一部分保留
+// 例如 "wav版"、"01_mp3" 中的格式字样会去掉，"escape"、"tape" 中的 ape 不会
+func stripToken(name, token string) string {
+	for i := 0; i < len(name); {
+		j := strings.Index(name[i:], token)
+		if j < 0 {
+			break
+		}
+		start, end := i+j, i+j+len(token)
+		if (start == 0 || !isASCIILetter(name[start-1])) && (end == len(name) || !isASCIILetter(name[end])) {
+			name = name[:start] + name[end:]
+			i = start
+		} else {
+			i = start + 1
+		}
+	}
+	return name
+}
+
+// pick 返回去重后的文件列表，不修改传入的 tracks
+func (p *formatPicker) pick(tracks []track) []track {
+	tracks = p.dedupeFiles(tracks)
+
+	// 同级文件夹按主要格式排序，较差格式的文件夹去掉已在较好格式中出现的文件
+	type candidate struct {
+		idx    int
+		format string
+		keys   map[string]bool
+	}
+	candidates := make([]candidate, 0)
+	for i, t := range tracks {
+		if t.Type != "folder" {
+			continue
+		}
+		if format := dominantFormat(t.Children); format != "" {
+			keys := make(map[string]bool)
+			audioKeys(t.Children, "", keys)
+			candidates = append(candidates, candidate{idx: i, format: format, keys: keys})
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return p.rankOf(candidates[a].format) < p.rankOf(candidates[b].format)
+	})
+
+	replaced := make(map[int]*track)
+	kept := make([]candidate, 0, len(candidates))
+	for _, c := range candidates {
+		duplicate := make(map[string]bool)
+		for _, k := range kept {
+			if p.rankOf(k.format) >= p.rankOf(c.format) {
+				continue
+			}
+			for key := range c.keys {
+				if k.keys[key] {
+					duplicate[key] = true
+				}
+			}
+		}
+		if len(duplicate) > 0 {
+			t := tracks[c.idx]
+			t.Children = removeAudio(t.Children, "", duplicate)
+			replaced[c.idx] = &t
+		}
+		kept = append(kept, c)
+	}
+
+	result := make([]track, 0, len(tracks))
+	for i, t := range tracks {
+		if r, ok := replaced[i]; ok {
+			// 文件夹已清空时整个跳过，只剩图片、文本等其他文件时保留
+			if len(r.Children) == 0 {
+				continue
+			}
+			t = *r
+		}
+		if t.Type == "folder" {
+			t.Children = p.pick(t.Children)
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// dedupeFiles 同一文件夹中同名不同格式的文件只保留最好的一个
+func (p *formatPicker) dedupeFiles(tracks []track) []track {
+	best := make(map[string]string)
+	for _, t := range tracks {
+		ext := audioExt(t.Title)
+		if t.Type == "folder" || ext == "" {
+			continue
+		}
+		key := normalizeTitle(t.Title)
+		if cur, ok := best[key]; !ok || p.rankOf(ext) < p.rankOf(cur) {
+			best[key] = ext
+		}
+	}
+	result := make([]track, 0, len(tracks))
+	for _, t := range tracks {
+		ext := audioExt(t.Title)
+		if t.Type != "folder" && ext != "" && best[normalizeTitle(t.Title)] != ext {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+// dominantFormat 文件夹中数量最多的音频格式，没有音频时返回空
+func dominantFormat(tracks []track) string {
+	counts := make(map[string]int)
+	countFormats(tracks, counts)
+	format, most := "", 0
+	for ext, n := range counts {
+		if n > most || (n == most && ext < format) {
+			format, most = ext, n
+		}
+	}
+	return format
+}
+
+func countFormats(tracks []track, counts map[string]int) {
+	for _, t := range tracks {
+		if t.Type == "folder" {
+			countFormats(t.Children, counts)
+		} else if ext := audioExt(t.Title); ext != "" {
+			counts[ext]++
+		}
+	}
+}
+
+// audioKeys 收集音频文件的匹配键（相对路径 + 规范化标题）
+func audioKeys(tracks []track, prefix string, keys map[string]bool) {
+	for _, t := range tracks {
+		if t.Type == "folder" {
+			audioKeys(t.Children, prefix+normalizeTitle(t.Title)+"/", keys)
+		} else if audioExt(t.Title) != "" {
+			keys[prefix+normalizeTitle(t.Title)] = true
+		}
+	}
+}
+
+// removeAudio 去掉匹配键在 keys 中的音频文件，清空的子文件夹一并去掉
+func removeAudio(tracks []track, prefix string, keys map[string]bool) []track {
+	result := make([]track, 0, len(tracks))
+	for _, t := range tracks {
+		if t.Type == "folder" {
+			t.Children = removeAudio(t.Children, prefix+normalizeTitle(t.Title)+"/", keys)
+			if len(t.Children) == 0 {
+				continue
+			}
+		} else if audioExt(t.Title) != "" && keys[prefix+normalizeTitle(t.Title)] {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
+}
@@ -0,0 +1,181 @@
+package spider
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func file(name string) track {
+	return track{Type: "audio", Title: name}
+}
+
+func folder(name string, children ...track) track {
+	return track{Type: "folder", Title: name, Children: children}
+}
+
+// paths 列出文件的相对路径，按字典序排列
+func paths(tracks []track) []string {
+	result := make([]string, 0)
+	var walk func([]track, string)
+	walk = func(tracks []track, dir string) {
+		for _, t := range tracks {
+			if t.Type == "folder" {
+				walk(t.Children, dir+t.Title+"/")
+			} else {
+				result = append(result, dir+t.Title)
+			}
+		}
+	}
+	walk(tracks, "")
+	sort.Strings(result)
+	return result
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"01 Intro.wav", "01intro"},
+		{"01_Intro.MP3", "01intro"},
+		{"01 Intro (FLAC).flac", "01intro"},
+		{"WAV版", "版"},
+		{"mp3_320k", "320k"},
+		{"wav mp3", ""},
+		{"01 Escape.wav", "01escape"},
+		{"Tape.mp3", "tape"},
+		{"Grape Juice.ogg", "grapejuice"},
+		{"wavy.mp3", "wavy"},
+		{"notes.txt", "notestxt"},
+	}
+	for _, tt := range tests {
+		if got := normalizeTitle(tt.name); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDominantFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []track
+		want   string
+	}{
+		{"no audio", []track{file("cover.jpg")}, ""},
+		{"majority", []track{file("1.mp3"), file("2.mp3"), file("3.wav")}, "mp3"},
+		{"nested", []track{folder("sub", file("1.flac"), file("2.flac")), file("3.wav")}, "flac"},
+		{"tie picks smaller name", []track{file("1.wav"), file("2.mp3")}, "mp3"},
+		{"tie ignores order", []track{file("1.mp3"), file("2.wav")}, "mp3"},
+	}
+	for _, tt := range tests {
+		if got := dominantFormat(tt.tracks); got != tt.want {
+			t.Errorf("%s: dominantFormat = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatPicker(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefs  []string
+		tracks []track
+		want   []string
+	}{
+		{
+			name:  "sibling folders keep preferred format",
+			prefs: []string{"flac", "wav", "mp3"},
+			tracks: []track{
+				folder("mp3", file("01 Intro.mp3"), file("02 Main.mp3")),
+				folder("wav", file("01 Intro.wav"), file("02 Main.wav")),
+			},
+			want: []string{"wav/01 Intro.wav", "wav/02 Main.wav"},
+		},
+		{
+			name:  "files only in the worse format are kept",
+			prefs: []string{"wav", "mp3"},
+			tracks: []track{
+				folder("MP3版", file("01.mp3"), file("02.mp3"), file("bonus.mp3")),
+				folder("WAV版", file("01.wav"), file("02.wav")),
+			},
+			want: []string{"MP3版/bonus.mp3", "WAV版/01.wav", "WAV版/02.wav"},
+		},
+		{
+			name:  "same folder keeps best file",
+			prefs: []string{"flac", "mp3"},
+			tracks: []track{
+				file("01.mp3"), file("01.flac"), file("02.mp3"), file("cover.jpg"),
+			},
+			want: []string{"01.flac", "02.mp3", "cover.jpg"},
+		},
+		{
+			name:  "prefs are case and dot insensitive",
+			prefs: []string{".MP3", "wav"},
+			tracks: []track{
+				file("01.wav"), file("01.mp3"),
+			},
+			want: []string{"01.mp3"},
+		},
+		{
+			name:  "unlisted formats tie and are both kept across folders",
+			prefs: []string{"flac"},
+			tracks: []track{
+				folder("ogg", file("01.ogg")),
+				folder("opus", file("01.opus")),
+			},
+			want: []string{"ogg/01.ogg", "opus/01.opus"},
+		},
+		{
+			name:  "unlisted formats tie in the same folder keep the first",
+			prefs: []string{"flac"},
+			tracks: []track{
+				file("01.opus"), file("01.ogg"),
+			},
+			want: []string{"01.opus"},
+		},
+		{
+			name:  "listed format beats unlisted",
+			prefs: []string{"mp3"},
+			tracks: []track{
+				folder("wav", file("01.wav")),
+				folder("mp3", file("01.mp3")),
+			},
+			want: []string{"mp3/01.mp3"},
+		},
+		{
+			name:  "words containing format names do not collide",
+			prefs: []string{"wav", "mp3"},
+			tracks: []track{
+				folder("wav", file("Escape.wav")),
+				folder("mp3", file("Esc.mp3")),
+			},
+			want: []string{"mp3/Esc.mp3", "wav/Escape.wav"},
+		},
+		{
+			name:  "non-audio files in a deduped folder are kept",
+			prefs: []string{"mp3", "wav"},
+			tracks: []track{
+				folder("mp3", file("01.mp3"), file("02.mp3")),
+				folder("wav", file("01.wav"), file("02.wav"), file("cover.jpg"), folder("scans", file("1.png"))),
+			},
+			want: []string{"mp3/01.mp3", "mp3/02.mp3", "wav/cover.jpg", "wav/scans/1.png"},
+		},
+		{
+			name:  "nested format folders",
+			prefs: []string{"flac", "mp3"},
+			tracks: []track{
+				folder("本編",
+					folder("flac", file("01.flac")),
+					folder("mp3", file("01.mp3")),
+				),
+			},
+			want: []string{"本編/flac/01.flac"},
+		},
+	}
+	for _, tt := range tests {
+		got := paths(newFormatPicker(tt.prefs).pick(tt.tracks))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: pick = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
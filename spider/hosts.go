@@ -0,0 +1,222 @@
+package spider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/utils"
+)
+
+// 失败的主机在冷却时间内排到最后
+const hostCooldown = 5 * time.Minute
+
+// ErrServerError 服务器 5xx 或 429 限流，可以换一个主机重试
+var ErrServerError = errors.New("server error")
+
+// HostPool 按顺序排列的镜像主机，失败的主机暂时排到最后
+type HostPool struct {
+	mu       sync.Mutex
+	hosts    []string
+	failedAt map[string]time.Time
+}
+
+// NewHostPool 创建主机列表，去掉结尾的 /
+func NewHostPool(hosts []string) *HostPool {
+	p := &HostPool{failedAt: make(map[string]time.Time)}
+	for _, h := range hosts {
+		if h = strings.TrimRight(strings.TrimSpace(h), "/"); h != "" {
+			p.hosts = append(p.hosts, h)
+		}
+	}
+	return p
+}
+
+// APIHosts asmr.one 接口镜像，来自配置 api_hosts
+var APIHosts = NewHostPool([]string{"https://api.asmr.one"})
+
+// MediaHosts 音频下载镜像，来自配置 media_hosts，为空时与 APIHosts 共用（下载地址在接口域名下时）
+var MediaHosts = NewHostPool(nil)
+
+// Hosts 按配置顺序返回主机，冷却中的主机排在最后
+func (p *HostPool) Hosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	healthy := make([]string, 0, len(p.hosts))
+	cooling := make([]string, 0)
+	for _, h := range p.hosts {
+		if t, ok := p.failedAt[h]; ok && time.Since(t) < hostCooldown {
+			cooling = append(cooling, h)
+		} else {
+			healthy = append(healthy, h)
+		}
+	}
+	return append(healthy, cooling...)
+}
+
+// MarkFailed 记录主机失败
+func (p *HostPool) MarkFailed(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failedAt[host] = time.Now()
+}
+
+// MarkOK 主机恢复正常
+func (p *HostPool) MarkOK(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.failedAt, host)
+}
+
+// Probe 并发请求所有主机上的 probePath，连接失败或 5xx 的主机标记为失败
+// 任何 5xx 以下的响应（包括 404）都说明主机可用，ctx 结束时不改变主机状态
+func (p *HostPool) Probe(ctx context.Context, probePath string) {
+	globalClient := utils.Client.Get().(*http.Client)
+	client := *globalClient
+	utils.Client.Put(globalClient)
+	client.Timeout = 10 * time.Second
+
+	var wg sync.WaitGroup
+	for _, h := range p.Hosts() {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			req, _ := http.NewRequestWithContext(ctx, "GET", host+probePath, nil)
+			req.Header.Set("Referer", "https://www.asmr.one/")
+			resp, err := client.Do(req)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				p.MarkFailed(host)
+				return
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				p.MarkFailed(host)
+			} else {
+				p.MarkOK(host)
+			}
+		}(h)
+	}
+	wg.Wait()
+}
+
+// Do 依次在各主机上执行 fn，遇到网络错误、5xx 或 Cloudflare 拦截时换下一个主机
+func (p *HostPool) Do(fn func(base string) error) error {
+	hosts := p.Hosts()
+	var err error
+	for i, host := range hosts {
+		err = fn(host)
+		if err == nil || !shouldFailover(err) {
+			if err == nil {
+				p.MarkOK(host)
+			}
+			return err
+		}
+		p.MarkFailed(host)
+		if i < len(hosts)-1 {
+			utils.Warning(i18n.T("api_host_failover", host, err))
+		}
+	}
+	return err
+}
+
+// shouldFailover 只有主机本身的问题才换主机，401、404 等在所有镜像上结果相同
+// 请求被 ctx 中断时直接返回，不标记主机失败
+func shouldFailover(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrServerError) || errors.Is(err, ErrCloudflare) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// NextURL 把下载地址换到列表中的下一个镜像，地址不属于任何镜像时原样返回
+func (p *HostPool) NextURL(rawURL string) string {
+	u, host, ok := p.find(rawURL)
+	if !ok {
+		return rawURL
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, h := range p.hosts {
+		if h == host && len(p.hosts) > 1 {
+			return withHost(u, p.hosts[(i+1)%len(p.hosts)], rawURL)
+		}
+	}
+	return rawURL
+}
+
+// PreferredURL 下载地址所在的镜像正在冷却时换到当前最优先的镜像，否则原样返回
+func (p *HostPool) PreferredURL(rawURL string) string {
+	u, host, ok := p.find(rawURL)
+	if !ok {
+		return rawURL
+	}
+	p.mu.Lock()
+	t, failed := p.failedAt[host]
+	p.mu.Unlock()
+	if !failed || time.Since(t) >= hostCooldown {
+		return rawURL
+	}
+	if hosts := p.Hosts(); hosts[0] != host {
+		return withHost(u, hosts[0], rawURL)
+	}
+	return rawURL
+}
+
+// Failover 下载地址所在的镜像连接失败或返回 5xx 时调用，标记该镜像失败并返回换到其他镜像的地址
+// 地址不属于任何镜像或没有其他镜像时返回空字符串
+func (p *HostPool) Failover(rawURL string) string {
+	u, host, ok := p.find(rawURL)
+	if !ok {
+		return ""
+	}
+	p.MarkFailed(host)
+	for _, h := range p.Hosts() {
+		if h != host {
+			return withHost(u, h, "")
+		}
+	}
+	return ""
+}
+
+// find 解析地址并找到它所属的镜像
+func (p *HostPool) find(rawURL string) (*url.URL, string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, "", false
+	}
+	origin := u.Scheme + "://" + u.Host
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, h := range p.hosts {
+		if strings.EqualFold(h, origin) {
+			return u, h, true
+		}
+	}
+	return nil, "", false
+}
+
+// withHost 把 u 的协议和域名换成 host，host 无法解析时返回 fallback
+func withHost(u *url.URL, host, fallback string) string {
+	h, err := url.Parse(host)
+	if err != nil {
+		return fallback
+	}
+	next := *u
+	next.Scheme, next.Host = h.Scheme, h.Host
+	return next.String()
+}
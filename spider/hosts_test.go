@@ -0,0 +1,155 @@
+package spider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewHostPool(t *testing.T) {
+	p := NewHostPool([]string{" https://a.example/ ", "", "https://b.example//"})
+	want := []string{"https://a.example", "https://b.example"}
+	if got := p.Hosts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Hosts = %v, want %v", got, want)
+	}
+}
+
+func TestHostsCooldownOrder(t *testing.T) {
+	p := NewHostPool([]string{"https://a.example", "https://b.example", "https://c.example"})
+	p.MarkFailed("https://a.example")
+	if got, want := p.Hosts(), []string{"https://b.example", "https://c.example", "https://a.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after a failed: Hosts = %v, want %v", got, want)
+	}
+	p.MarkFailed("https://b.example")
+	if got, want := p.Hosts(), []string{"https://c.example", "https://a.example", "https://b.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after b failed: Hosts = %v, want %v", got, want)
+	}
+
+	// 冷却结束后恢复配置顺序
+	p.failedAt["https://a.example"] = time.Now().Add(-hostCooldown)
+	if got, want := p.Hosts(), []string{"https://a.example", "https://c.example", "https://b.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after a cooled down: Hosts = %v, want %v", got, want)
+	}
+	p.MarkOK("https://b.example")
+	if got, want := p.Hosts(), []string{"https://a.example", "https://b.example", "https://c.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after b recovered: Hosts = %v, want %v", got, want)
+	}
+}
+
+func TestNextURL(t *testing.T) {
+	p := NewHostPool([]string{"https://a.example", "https://b.example"})
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://a.example/media/1.wav?token=x", "https://b.example/media/1.wav?token=x"},
+		{"https://b.example/media/1.wav", "https://a.example/media/1.wav"},
+		{"https://A.example/media/1.wav", "https://b.example/media/1.wav"},
+		{"https://other.example/media/1.wav", "https://other.example/media/1.wav"},
+		{"not a url", "not a url"},
+	}
+	for _, tt := range tests {
+		if got := p.NextURL(tt.in); got != tt.want {
+			t.Errorf("NextURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := NewHostPool([]string{"https://a.example"}).NextURL("https://a.example/1.wav"); got != "https://a.example/1.wav" {
+		t.Errorf("single host NextURL = %q", got)
+	}
+}
+
+func TestPreferredURL(t *testing.T) {
+	p := NewHostPool([]string{"https://a.example", "https://b.example"})
+	u := "https://a.example/media/1.wav?token=x"
+	if got := p.PreferredURL(u); got != u {
+		t.Errorf("healthy host: PreferredURL = %q", got)
+	}
+	p.MarkFailed("https://a.example")
+	if got, want := p.PreferredURL(u), "https://b.example/media/1.wav?token=x"; got != want {
+		t.Errorf("cooling host: PreferredURL = %q, want %q", got, want)
+	}
+	// 所有镜像都在冷却时，最早失败的排在前面
+	p.MarkFailed("https://b.example")
+	if got := p.PreferredURL("https://b.example/1.wav"); got != "https://a.example/1.wav" {
+		t.Errorf("all cooling: PreferredURL = %q", got)
+	}
+	if got := p.PreferredURL("https://other.example/1.wav"); got != "https://other.example/1.wav" {
+		t.Errorf("unknown host: PreferredURL = %q", got)
+	}
+}
+
+func TestFailover(t *testing.T) {
+	p := NewHostPool([]string{"https://a.example", "https://b.example", "https://c.example"})
+	next := p.Failover("https://a.example/media/1.wav?token=x")
+	if next != "https://b.example/media/1.wav?token=x" {
+		t.Errorf("first Failover = %q", next)
+	}
+	if got := p.Hosts()[2]; got != "https://a.example" {
+		t.Errorf("failed host should move last, Hosts = %v", p.Hosts())
+	}
+	if next = p.Failover(next); next != "https://c.example/media/1.wav?token=x" {
+		t.Errorf("second Failover = %q", next)
+	}
+	if got := p.Failover("https://other.example/1.wav"); got != "" {
+		t.Errorf("unknown host: Failover = %q", got)
+	}
+	if got := NewHostPool([]string{"https://a.example"}).Failover("https://a.example/1.wav"); got != "" {
+		t.Errorf("single host: Failover = %q", got)
+	}
+}
+
+func TestDoFailover(t *testing.T) {
+	p := NewHostPool([]string{"https://a.example", "https://b.example"})
+	tried := make([]string, 0)
+	err := p.Do(func(base string) error {
+		tried = append(tried, base)
+		if base == "https://a.example" {
+			return fmt.Errorf("status 502: %w", ErrServerError)
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(tried, []string{"https://a.example", "https://b.example"}) {
+		t.Errorf("Do = %v, tried %v", err, tried)
+	}
+	if got := p.Hosts()[0]; got != "https://b.example" {
+		t.Errorf("Hosts after failover = %v", p.Hosts())
+	}
+
+	// 与主机无关的错误不换主机
+	tried = tried[:0]
+	err = p.Do(func(base string) error {
+		tried = append(tried, base)
+		return ErrUnauthorized
+	})
+	if err != ErrUnauthorized || len(tried) != 1 {
+		t.Errorf("Do with 401 = %v, tried %v", err, tried)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	ok := httptest.NewServer(http.NotFoundHandler())
+	defer ok.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	p := NewHostPool([]string{down.URL, ok.URL})
+	p.Probe(context.Background(), "/api/health")
+	if got, want := p.Hosts(), []string{ok.URL, down.URL}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Hosts after probe = %v, want %v", got, want)
+	}
+
+	// 被取消的探测不改变主机状态
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = NewHostPool([]string{down.URL, ok.URL})
+	p.Probe(ctx, "/api/health")
+	if got, want := p.Hosts(), []string{down.URL, ok.URL}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Hosts after canceled probe = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,15 @@
+package spider
+
+import (
+	"os"
+	"testing"
+)
+
+// TestMain 包初始化时会在当前目录生成默认的 config.json 和 jobs.jsonl，测试结束后删除
+func TestMain(m *testing.M) {
+	code := m.Run()
+	_ = Jobs.Close()
+	_ = os.Remove("config.json")
+	_ = os.Remove(JobStoreFile)
+	os.Exit(code)
+}
@@ -0,0 +1,178 @@
+package spider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/utils"
+)
+
+// MetadataFile 作品目录中的元数据文件名
+const MetadataFile = "info.json"
+
+// WorkInfo /api/workInfo/{id} 返回的作品信息（只列出用到的字段，info.json 保存完整响应）
+type WorkInfo struct {
+	ID       int    `json:"id"`
+	SourceID string `json:"source_id"`
+	Title    string `json:"title"`
+	Release  string `json:"release"`
+	Circle   struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"circle"`
+	VAs []struct {
+		Name string `json:"name"`
+	} `json:"vas"`
+	Tags []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	MainCoverURL      string `json:"mainCoverUrl"`
+	ThumbnailCoverURL string `json:"thumbnailCoverUrl"`
+
+	// raw 原始响应，写入 info.json
+	raw []byte
+}
+
+// GetWorkInfo 获取作品信息
+func (ac *ASMRClient) GetWorkInfo(id string) (*WorkInfo, error) {
+	all, err := ac.apiGet("/api/workInfo/" + id)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &WorkInfo{}
+	if err := json.Unmarshal(all, info); err != nil {
+		return nil, err
+	}
+	info.raw = all
+	return info, nil
+}
+
+// SaveMetadata 将 info.json 和封面写入作品目录，每个文件单独检查，只补齐缺少的文件
+// 某个文件失败时继续处理其余文件，返回第一个错误，下次运行时重试
+func (ac *ASMRClient) SaveMetadata(rj string, info *WorkInfo, basePath string) error {
+	tempDir := filepath.Join(LocalTempDir, filepath.FromSlash(basePath))
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return err
+	}
+
+	var firstErr error
+	saved := 0
+	infoPath := basePath + "/" + MetadataFile
+	if !Storage.Exists(ac.ctx, infoPath) {
+		var buf bytes.Buffer
+		data := info.raw
+		if json.Indent(&buf, info.raw, "", "  ") == nil {
+			data = buf.Bytes()
+		}
+		tempPath := filepath.Join(tempDir, MetadataFile)
+		err := os.WriteFile(tempPath, data, 0644)
+		if err == nil {
+			err = putFile(ac.ctx, tempPath, infoPath)
+		}
+		if err != nil {
+			firstErr = err
+		} else {
+			saved++
+		}
+	}
+
+	for _, c := range coverFiles(info) {
+		finalPath := basePath + "/" + c.name
+		if Storage.Exists(ac.ctx, finalPath) {
+			continue
+		}
+		tempPath := filepath.Join(tempDir, c.name)
+		err := ac.fetchFile(c.url, tempPath)
+		if err == nil {
+			err = putFile(ac.ctx, tempPath, finalPath)
+		}
+		if err != nil {
+			_ = os.Remove(tempPath)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		saved++
+	}
+	if saved > 0 {
+		utils.Info(i18n.T("metadata_saved", rj))
+	}
+	return firstErr
+}
+
+type coverFile struct {
+	name string
+	url  string
+}
+
+// coverFiles 作品目录中的封面文件名及下载地址，没有地址的封面不保存
+func coverFiles(info *WorkInfo) []coverFile {
+	covers := make([]coverFile, 0, 2)
+	for _, c := range []coverFile{
+		{"cover", info.MainCoverURL},
+		{"thumbnail", info.ThumbnailCoverURL},
+	} {
+		if c.url != "" {
+			covers = append(covers, coverFile{name: c.name + coverExt(c.url), url: c.url})
+		}
+	}
+	return covers
+}
+
+// coverExt 从封面地址中取扩展名，例如 /api/cover/RJ123456.jpg?type=main
+func coverExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err == nil {
+		if ext := path.Ext(u.Path); ext != "" {
+			return ext
+		}
+	}
+	return ".jpg"
+}
+
+// fetchFile 下载小文件（封面等），不经过 WorkerPool
+func (ac *ASMRClient) fetchFile(rawURL, dst string) error {
+	client := utils.Client.Get().(*http.Client)
+	req, _ := http.NewRequestWithContext(ac.ctx, "GET", rawURL, nil)
+	req.Header.Set("Referer", "https://www.asmr.one/")
+	req.Header.Set("User-Agent", "PostmanRuntime/7.29.0")
+	resp, err := client.Do(req)
+	utils.Client.Put(client)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", rawURL, resp.StatusCode)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// putFile 写入存储后端并删除临时文件
+func putFile(ctx context.Context, tempPath, finalPath string) error {
+	if err := Storage.Put(ctx, tempPath, finalPath); err != nil {
+		return err
+	}
+	_ = os.Remove(tempPath)
+	return nil
+}
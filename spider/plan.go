@@ -0,0 +1,251 @@
+package spider
+
+import (
+	"context"
+
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/utils"
+)
+
+// 计划中每个文件的处理方式
+const (
+	PlanDownload = "download" // 需要下载
+	PlanPresent  = "present"  // 存储后端上已存在
+	PlanFiltered = "filtered" // 被过滤规则跳过
+	PlanDeduped  = "deduped"  // 其他格式的同一内容会被下载
+)
+
+// 被过滤的原因
+const (
+	ReasonFolder    = "folder"
+	ReasonExtension = "extension"
+	ReasonSize      = "size"
+)
+
+// PlanOptions 生成下载计划时的选项
+type PlanOptions struct {
+	// Sizes 对接口未返回大小的文件逐个 HEAD 获取大小
+	Sizes bool
+	// CheckExisting 检查存储后端上已存在的文件
+	CheckExisting bool
+}
+
+// PlanCount 文件数与总大小
+type PlanCount struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// PlanSummary 按处理方式统计
+type PlanSummary struct {
+	Download PlanCount `json:"download"`
+	Present  PlanCount `json:"present"`
+	Filtered PlanCount `json:"filtered"`
+	Deduped  PlanCount `json:"deduped"`
+}
+
+func (s *PlanSummary) add(action string, size int64) {
+	var c *PlanCount
+	switch action {
+	case PlanDownload:
+		c = &s.Download
+	case PlanPresent:
+		c = &s.Present
+	case PlanFiltered:
+		c = &s.Filtered
+	case PlanDeduped:
+		c = &s.Deduped
+	default:
+		return
+	}
+	c.Files++
+	c.Size += size
+}
+
+// Merge 累加另一组统计
+func (s *PlanSummary) Merge(o *PlanSummary) {
+	for _, pair := range [][2]*PlanCount{
+		{&s.Download, &o.Download}, {&s.Present, &o.Present},
+		{&s.Filtered, &o.Filtered}, {&s.Deduped, &o.Deduped},
+	} {
+		pair[0].Files += pair[1].Files
+		pair[0].Size += pair[1].Size
+	}
+}
+
+// PlanNode 文件树中的一个文件或文件夹，Path 相对于存储根目录
+type PlanNode struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Folder bool   `json:"folder,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// StreamURL 下载地址为空或返回 403 时使用的 mediaStreamUrl
+	StreamURL string       `json:"stream_url,omitempty"`
+	Size      int64        `json:"size,omitempty"`
+	Action    string       `json:"action,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	Summary   *PlanSummary `json:"summary,omitempty"`
+	Children  []*PlanNode  `json:"children,omitempty"`
+}
+
+// Plan 单个作品的下载计划
+type Plan struct {
+	RJ       string      `json:"rj"`
+	BasePath string      `json:"base_path"`
+	Summary  PlanSummary `json:"summary"`
+	Root     *PlanNode   `json:"tree"`
+
+	info *WorkInfo
+}
+
+// Plan 获取作品文件列表，按目录模板、格式偏好和过滤规则决定每个文件的去向，不加入下载队列
+// id 为不带 RJ 前缀的作品编号
+func (ac *ASMRClient) Plan(id string, opts PlanOptions) (*Plan, error) {
+	rj := "RJ" + id
+
+	// 目录模板或元数据需要作品信息
+	var info *WorkInfo
+	if Conf.Metadata || templateNeedsInfo(Conf.FolderTemplate) {
+		var err error
+		info, err = ac.GetWorkInfo(id)
+		if err != nil && templateNeedsInfo(Conf.FolderTemplate) {
+			// 按模板无法确定目录时不下载，避免同一作品落到不同目录
+			return nil, err
+		}
+	}
+	// 路径均相对于存储根目录，临时目录使用相同的结构
+	basePath, err := RenderFolder(Conf.FolderTemplate, rj, info)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := filterFor(rj)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := ac.GetVoiceTracks(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 格式偏好保留下来的文件
+	picked, _ := filter.PreferFormats(tracks)
+	kept := make(map[string]bool)
+	collectPaths(picked, basePath, kept)
+
+	p := &planner{ctx: ac.ctx, filter: filter, kept: kept, opts: opts}
+	root := &PlanNode{Name: basePath, Path: basePath, Folder: true}
+	p.walk(root, tracks, "")
+
+	return &Plan{
+		RJ:       rj,
+		BasePath: basePath,
+		Summary:  *root.Summary,
+		Root:     root,
+		info:     info,
+	}, nil
+}
+
+func collectPaths(tracks []track, dir string, paths map[string]bool) {
+	for _, t := range tracks {
+		if t.Type == "folder" {
+			collectPaths(t.Children, dir+"/"+sanitizeTitle(t.Title), paths)
+		} else {
+			paths[dir+"/"+sanitizeTitle(t.Title)] = true
+		}
+	}
+}
+
+type planner struct {
+	ctx    context.Context
+	filter *Filter
+	kept   map[string]bool
+	opts   PlanOptions
+}
+
+// walk 填充 node 的子节点，folderReason 不为空时整个文件夹已被过滤
+func (p *planner) walk(node *PlanNode, tracks []track, folderReason string) {
+	node.Summary = &PlanSummary{}
+	for _, t := range tracks {
+		// 标题中的 / 和 .. 不能产生额外的目录
+		name := sanitizeTitle(t.Title)
+		path := node.Path + "/" + name
+		if t.Type == "folder" {
+			reason := folderReason
+			if reason == "" && p.filter.SkipFolder(t.Title) {
+				reason = ReasonFolder
+			}
+			child := &PlanNode{Name: name, Path: path, Folder: true}
+			if reason != "" {
+				child.Action, child.Reason = PlanFiltered, reason
+			}
+			p.walk(child, t.Children, reason)
+			node.Summary.Merge(child.Summary)
+			node.Children = append(node.Children, child)
+			continue
+		}
+
+		child := &PlanNode{Name: name, Path: path, URL: t.MediaDownloadURL, StreamURL: t.MediaStreamURL, Size: t.Size}
+		if child.URL == "" {
+			// 部分受限作品没有下载地址，只能使用流媒体地址
+			child.URL = t.MediaStreamURL
+		}
+		if child.Size == 0 && (p.opts.Sizes || (folderReason == "" && p.filter.LimitsSize())) {
+			child.Size = remoteSize(p.ctx, child.URL)
+		}
+		switch {
+		case folderReason != "":
+			child.Action, child.Reason = PlanFiltered, folderReason
+		case !p.kept[path]:
+			child.Action = PlanDeduped
+		case p.filter.SkipExt(t.Title):
+			child.Action, child.Reason = PlanFiltered, ReasonExtension
+		case p.filter.SkipSize(child.Size):
+			child.Action, child.Reason = PlanFiltered, ReasonSize
+		default:
+			child.Action = PlanDownload
+			if p.opts.CheckExisting {
+				if info, err := Storage.Stat(p.ctx, path); err == nil && !info.IsDir && (child.Size == 0 || child.Size == info.Size) {
+					child.Action = PlanPresent
+					child.Size = info.Size
+				}
+			}
+		}
+		node.Summary.add(child.Action, child.Size)
+		node.Children = append(node.Children, child)
+	}
+}
+
+// remoteSize HEAD 获取文件大小，失败时返回 0
+func remoteSize(ctx context.Context, url string) int64 {
+	size, err := utils.GetRemoteFileSize(ctx, url, map[string]string{
+		"Referer": "https://www.asmr.one/",
+	})
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// enqueuePlan 将计划中需要下载的文件加入队列
+// 目录在第一个需要下载的文件之前才创建，整个被过滤的文件夹不会留下空目录
+func (ac *ASMRClient) enqueuePlan(rj string, node *PlanNode) {
+	created := false
+	for _, child := range node.Children {
+		if child.Folder {
+			ac.enqueuePlan(rj, child)
+			continue
+		}
+		if child.Action != PlanDownload {
+			continue
+		}
+		if !created {
+			if err := Storage.Mkdir(ac.ctx, node.Path); err != nil {
+				utils.Warning(i18n.T("file_error", err))
+			}
+			created = true
+		}
+		ac.DownloadFile(rj, child.URL, child.StreamURL, node.Path, child.Name)
+	}
+}
@@ -0,0 +1,107 @@
+package spider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// workCodePattern DLsite 作品编号，asmr.one 只收录 RJ（同人音声），BJ/VJ 会被识别但跳过
+var workCodePattern = regexp.MustCompile(`(?i)([RBV]J)(\d+)`)
+
+// NormalizeRJ 将 rj123456、RJ01234567、123456 等写法统一为大写的 RJ 号
+// 数字部分必须是 6 位或 8 位
+func NormalizeRJ(s string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(s))
+	digits := strings.TrimPrefix(code, "RJ")
+	if !isWorkNumber(digits) {
+		return "", fmt.Errorf("invalid RJ code %q", s)
+	}
+	return "RJ" + digits, nil
+}
+
+// ExtractResult 从文本中提取编号的结果，各列表按出现顺序去重
+type ExtractResult struct {
+	RJs []string `json:"rjs"`
+	// Unsupported asmr.one 未收录的 BJ/VJ 编号
+	Unsupported []string `json:"unsupported"`
+	// Invalid 像是编号但位数不对，例如 RJ12345
+	Invalid []string `json:"invalid"`
+	// Ignored 不含任何编号的片段
+	Ignored []string `json:"ignored"`
+}
+
+// ExtractRJ 从任意文本（RJ 号列表、asmr.one/DLsite 链接、愿望单导出等）中提取 RJ 号
+// 以空白、逗号、分号分隔的纯数字也视为 RJ 号
+func ExtractRJ(text string) *ExtractResult {
+	res := &ExtractResult{
+		RJs:         make([]string, 0),
+		Unsupported: make([]string, 0),
+		Invalid:     make([]string, 0),
+		Ignored:     make([]string, 0),
+	}
+	seen := make(map[string]bool)
+	add := func(list *[]string, s string) {
+		if !seen[s] {
+			seen[s] = true
+			*list = append(*list, s)
+		}
+	}
+
+	tokens := strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == ';' || r == '，' || r == '、'
+	})
+	for _, token := range tokens {
+		if isDigits(token) {
+			if isWorkNumber(token) {
+				add(&res.RJs, "RJ"+token)
+			} else {
+				add(&res.Invalid, token)
+			}
+			continue
+		}
+		found := false
+		for _, m := range workCodePattern.FindAllStringSubmatchIndex(token, -1) {
+			// 前面紧跟字母时不是编号，例如 "ABJ123456"
+			if m[0] > 0 && isASCIILetter(token[m[0]-1]) {
+				continue
+			}
+			found = true
+			code := strings.ToUpper(token[m[2]:m[3]]) + token[m[4]:m[5]]
+			switch {
+			case !isWorkNumber(token[m[4]:m[5]]):
+				add(&res.Invalid, token[m[0]:m[1]])
+			case strings.HasPrefix(code, "RJ"):
+				add(&res.RJs, code)
+			default:
+				add(&res.Unsupported, code)
+			}
+		}
+		if !found {
+			add(&res.Ignored, token)
+		}
+	}
+	return res
+}
+
+// isWorkNumber 6 位或 8 位数字
+func isWorkNumber(s string) bool {
+	return (len(s) == 6 || len(s) == 8) && isDigits(s)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,143 @@
+package spider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeRJ(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"RJ123456", "RJ123456", false},
+		{"rj123456", "RJ123456", false},
+		{" Rj123456 ", "RJ123456", false},
+		{"123456", "RJ123456", false},
+		{"RJ01234567", "RJ01234567", false},
+		{"01234567", "RJ01234567", false},
+		{"RJ012345", "RJ012345", false},
+		{"RJ12345", "", true},
+		{"RJ1234567", "", true},
+		{"RJ123456789", "", true},
+		{"RJ", "", true},
+		{"", "", true},
+		{"BJ123456", "", true},
+		{"RJ12345a", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeRJ(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeRJ(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExtractRJ(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want ExtractResult
+	}{
+		{
+			name: "codes and bare numbers",
+			text: "RJ123456 rj234567, 345678;RJ01234567",
+			want: ExtractResult{RJs: []string{"RJ123456", "RJ234567", "RJ345678", "RJ01234567"}},
+		},
+		{
+			name: "separators",
+			text: "RJ123456，RJ234567、RJ345678;RJ456789\nRJ567890",
+			want: ExtractResult{RJs: []string{"RJ123456", "RJ234567", "RJ345678", "RJ456789", "RJ567890"}},
+		},
+		{
+			name: "urls",
+			text: "https://www.asmr.one/work/RJ01234567 https://www.dlsite.com/maniax/work/=/product_id/RJ123456.html",
+			want: ExtractResult{RJs: []string{"RJ01234567", "RJ123456"}},
+		},
+		{
+			name: "lowercase in url",
+			text: "https://asmr.one/work/rj123456?x=1",
+			want: ExtractResult{RJs: []string{"RJ123456"}},
+		},
+		{
+			name: "duplicates keep first occurrence",
+			text: "RJ123456 rj123456 123456 RJ234567",
+			want: ExtractResult{RJs: []string{"RJ123456", "RJ234567"}},
+		},
+		{
+			name: "leading zero eight digits",
+			text: "RJ01000000 01000001",
+			want: ExtractResult{RJs: []string{"RJ01000000", "RJ01000001"}},
+		},
+		{
+			name: "wrong digit count",
+			text: "RJ12345 RJ1234567 12345",
+			want: ExtractResult{Invalid: []string{"RJ12345", "RJ1234567", "12345"}},
+		},
+		{
+			name: "unsupported prefixes",
+			text: "BJ123456 vj01234567 RJ123456",
+			want: ExtractResult{RJs: []string{"RJ123456"}, Unsupported: []string{"BJ123456", "VJ01234567"}},
+		},
+		{
+			name: "letter before prefix is not a code",
+			text: "ABJ123456 xRJ123456",
+			want: ExtractResult{Ignored: []string{"ABJ123456", "xRJ123456"}},
+		},
+		{
+			name: "text without codes",
+			text: "hello RJ123456 world",
+			want: ExtractResult{RJs: []string{"RJ123456"}, Ignored: []string{"hello", "world"}},
+		},
+		{
+			name: "empty",
+			text: "  \n ",
+			want: ExtractResult{},
+		},
+	}
+	for _, tt := range tests {
+		got := ExtractRJ(tt.text)
+		for _, list := range []*[]string{&tt.want.RJs, &tt.want.Unsupported, &tt.want.Invalid, &tt.want.Ignored} {
+			if *list == nil {
+				*list = []string{}
+			}
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: ExtractRJ(%q) = %+v, want %+v", tt.name, tt.text, *got, tt.want)
+		}
+	}
+}
+
+// TestExtractRJImport 从文件、标准输入和剪贴板导入的整段文本
+func TestExtractRJImport(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "wishlist csv export",
+			text: "作品名,サークル,URL\r\n" +
+				"\"耳かき\",CircleA,https://www.dlsite.com/maniax/work/=/product_id/RJ123456.html\r\n" +
+				"\"添い寝\",CircleB,https://www.dlsite.com/maniax/work/=/product_id/RJ01234567.html\r\n",
+			want: []string{"RJ123456", "RJ01234567"},
+		},
+		{
+			name: "html clipboard dump",
+			text: `<a href="https://asmr.one/work/RJ234567">RJ234567</a><a href="/work/RJ345678?lang=ja">`,
+			want: []string{"RJ234567", "RJ345678"},
+		},
+		{
+			name: "one code per line with comments",
+			text: "# 待下载\nRJ123456\n\n  rj234567  \n123456\n",
+			want: []string{"RJ123456", "RJ234567"},
+		},
+	}
+	for _, tt := range tests {
+		got := ExtractRJ(tt.text)
+		if !reflect.DeepEqual(got.RJs, tt.want) {
+			t.Errorf("%s: RJs = %v, want %v", tt.name, got.RJs, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package spider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"re-asmr-spider/utils"
+)
+
+// searchPageSize 每页请求的作品数
+const searchPageSize = 50
+
+// SearchDateLayout --since/--until 的日期格式，与接口返回的 release 相同
+const SearchDateLayout = "2006-01-02"
+
+// ErrEmptySearch 没有任何搜索条件
+var ErrEmptySearch = errors.New("no search keyword or filter given")
+
+// SearchOptions 搜索条件，多个条件同时满足
+type SearchOptions struct {
+	Keyword string
+	// Tags/VAs 可指定多个，以 - 开头表示排除，例如 -男性向け
+	Tags    []string
+	VAs     []string
+	Circles []string
+	// Since/Until 按发售日过滤（包含当天），零值表示不限制
+	Since time.Time
+	Until time.Time
+	// Max 最多返回的作品数，0 表示不限制
+	Max int
+}
+
+// searchResult /api/search 的响应
+type searchResult struct {
+	Works      []WorkInfo `json:"works"`
+	Pagination struct {
+		CurrentPage int `json:"currentPage"`
+		PageSize    int `json:"pageSize"`
+		TotalCount  int `json:"totalCount"`
+	} `json:"pagination"`
+}
+
+// Query 按 asmr.one 的搜索语法拼接关键词，例如 "催眠 $tag:耳かき$ $va:xxx$"
+func (o *SearchOptions) Query() string {
+	parts := make([]string, 0)
+	if kw := strings.TrimSpace(o.Keyword); kw != "" {
+		parts = append(parts, kw)
+	}
+	add := func(field string, values []string) {
+		for _, v := range values {
+			f, v := field, strings.TrimSpace(v)
+			if strings.HasPrefix(v, "-") {
+				f, v = "-"+field, strings.TrimPrefix(v, "-")
+			}
+			if v != "" {
+				parts = append(parts, "$"+f+":"+v+"$")
+			}
+		}
+	}
+	add("tag", o.Tags)
+	add("va", o.VAs)
+	add("circle", o.Circles)
+	return strings.Join(parts, " ")
+}
+
+// RJ 作品的 RJ 号，优先使用接口返回的 source_id
+func (w *WorkInfo) RJ() string {
+	if strings.HasPrefix(strings.ToUpper(w.SourceID), "RJ") {
+		return strings.ToUpper(w.SourceID)
+	}
+	if w.ID >= 1000000 {
+		return fmt.Sprintf("RJ%08d", w.ID)
+	}
+	return fmt.Sprintf("RJ%06d", w.ID)
+}
+
+// Search 按发售日从新到旧翻页搜索，返回符合条件的作品和接口报告的总数
+// 早于 Since 的作品出现后不再继续翻页
+func (ac *ASMRClient) Search(opts SearchOptions) ([]WorkInfo, int, error) {
+	query := opts.Query()
+	if query == "" {
+		return nil, 0, ErrEmptySearch
+	}
+
+	works := make([]WorkInfo, 0)
+	total := 0
+	for page := 1; ; page++ {
+		utils.GlobalMonitor.UpdateActivity()
+		params := url.Values{}
+		params.Set("order", "release")
+		params.Set("sort", "desc")
+		params.Set("page", fmt.Sprint(page))
+		params.Set("pageSize", fmt.Sprint(searchPageSize))
+		params.Set("subtitle", "0")
+		all, err := ac.apiGet("/api/search/" + url.PathEscape(query) + "?" + params.Encode())
+		if err != nil {
+			return works, total, err
+		}
+		res := searchResult{}
+		if err := json.Unmarshal(all, &res); err != nil {
+			return works, total, err
+		}
+		total = res.Pagination.TotalCount
+
+		older := false
+		for _, w := range res.Works {
+			release, err := time.Parse(SearchDateLayout, w.Release)
+			if err == nil {
+				if !opts.Until.IsZero() && release.After(opts.Until) {
+					continue
+				}
+				if !opts.Since.IsZero() && release.Before(opts.Since) {
+					older = true
+					continue
+				}
+			}
+			works = append(works, w)
+			if opts.Max > 0 && len(works) >= opts.Max {
+				return works, total, nil
+			}
+		}
+		pageSize := res.Pagination.PageSize
+		if pageSize <= 0 {
+			pageSize = searchPageSize
+		}
+		if older || len(res.Works) == 0 || page*pageSize >= total {
+			return works, total, nil
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package spider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want string
+	}{
+		{"empty", SearchOptions{}, ""},
+		{"keyword only", SearchOptions{Keyword: " 催眠 "}, "催眠"},
+		{"fields", SearchOptions{Tags: []string{"耳かき"}, VAs: []string{"va1"}, Circles: []string{"c1"}}, "$tag:耳かき$ $va:va1$ $circle:c1$"},
+		{"exclude tag", SearchOptions{Keyword: "kw", Tags: []string{"-男性向け", " 耳かき "}}, "kw $-tag:男性向け$ $tag:耳かき$"},
+		{"blank values skipped", SearchOptions{Tags: []string{"", " ", "-"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.Query(); got != tt.want {
+			t.Errorf("%s: Query = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWorkInfoRJ(t *testing.T) {
+	tests := []struct {
+		info WorkInfo
+		want string
+	}{
+		{WorkInfo{ID: 123456, SourceID: "rj123456"}, "RJ123456"},
+		{WorkInfo{ID: 123456}, "RJ123456"},
+		{WorkInfo{ID: 12345}, "RJ012345"},
+		{WorkInfo{ID: 1234567}, "RJ01234567"},
+		{WorkInfo{ID: 123456, SourceID: "VJ123456"}, "RJ123456"},
+	}
+	for _, tt := range tests {
+		if got := tt.info.RJ(); got != tt.want {
+			t.Errorf("%+v.RJ() = %q, want %q", tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	// 三页作品，按发售日从新到旧排列
+	releases := []string{"2024-03-01", "2024-02-20", "2024-02-10", "2024-02-01", "2024-01-20", "2024-01-10"}
+	pages := make([]int, 0)
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ = url.PathUnescape(r.URL.EscapedPath())
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pages = append(pages, page)
+		res := searchResult{}
+		res.Pagination.PageSize = 2
+		res.Pagination.TotalCount = len(releases)
+		for i := (page - 1) * 2; i < page*2 && i < len(releases); i++ {
+			res.Works = append(res.Works, WorkInfo{ID: 100000 + i, Release: releases[i]})
+		}
+		_ = json.NewEncoder(w).Encode(&res)
+	}))
+	defer srv.Close()
+
+	hosts := APIHosts
+	defer func() { APIHosts = hosts }()
+	APIHosts = NewHostPool([]string{srv.URL})
+	ac := &ASMRClient{ctx: context.Background()}
+
+	day := func(s string) time.Time {
+		d, _ := time.Parse(SearchDateLayout, s)
+		return d
+	}
+	tests := []struct {
+		name      string
+		opts      SearchOptions
+		wantRJs   []string
+		wantPages []int
+	}{
+		{"all pages", SearchOptions{Tags: []string{"tag"}}, []string{"RJ100000", "RJ100001", "RJ100002", "RJ100003", "RJ100004", "RJ100005"}, []int{1, 2, 3}},
+		{"max stops early", SearchOptions{Tags: []string{"tag"}, Max: 3}, []string{"RJ100000", "RJ100001", "RJ100002"}, []int{1, 2}},
+		{"since stops at older works", SearchOptions{Tags: []string{"tag"}, Since: day("2024-02-05")}, []string{"RJ100000", "RJ100001", "RJ100002"}, []int{1, 2}},
+		{"until skips newer works", SearchOptions{Tags: []string{"tag"}, Until: day("2024-02-10"), Since: day("2024-02-01")}, []string{"RJ100002", "RJ100003"}, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		pages = pages[:0]
+		works, total, err := ac.Search(tt.opts)
+		if err != nil {
+			t.Fatalf("%s: Search: %v", tt.name, err)
+		}
+		rjs := make([]string, 0, len(works))
+		for _, w := range works {
+			rjs = append(rjs, w.RJ())
+		}
+		if !reflect.DeepEqual(rjs, tt.wantRJs) || total != len(releases) || !reflect.DeepEqual(pages, tt.wantPages) {
+			t.Errorf("%s: Search = %v (total %d, pages %v), want %v (pages %v)", tt.name, rjs, total, pages, tt.wantRJs, tt.wantPages)
+		}
+	}
+	if query != "/api/search/$tag:tag$" {
+		t.Errorf("request path = %q", query)
+	}
+
+	if _, _, err := ac.Search(SearchOptions{}); err != ErrEmptySearch {
+		t.Errorf("empty search error = %v, want ErrEmptySearch", err)
+	}
+}
@@ -1,11 +1,10 @@
 package spider
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"runtime"
 	"strings"
@@ -14,46 +13,23 @@ import (
 
 	"re-asmr-spider/config"
 	"re-asmr-spider/i18n"
+	"re-asmr-spider/jobs"
 	"re-asmr-spider/utils"
 )
 
 var Conf *config.Config
-const LocalTempDir = "/root/asmr_temp"
-func moveFile(src, dst string) error {
-	// 尝试直接重命名（如果是在同一个分区可能成功，但挂载点通常不行）
-	err := os.Rename(src, dst)
-	if err == nil {
-		return nil
-	}
 
-	// 如果重命名失败（跨设备移动），则进行 复制+删除
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer sourceFile.Close()
+// LocalTempDir 本地临时目录，来自配置 storage.temp_dir
+var LocalTempDir = "/root/asmr_temp"
 
-	// 确保目标目录存在
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		return err
-	}
+// Storage 下载完成后文件写入的存储后端，来自配置 storage
+var Storage utils.Storage
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
+// JobStoreFile 按文件记录下载状态的任务库
+const JobStoreFile = "jobs.jsonl"
 
-	_, err = io.Copy(destFile, sourceFile)
-	if err != nil {
-		return err
-	}
-
-	// 复制成功后，关闭文件并删除源文件
-	sourceFile.Close()
-	destFile.Close()
-	return os.Remove(src)
-}
+// Jobs 全局任务库，看门狗重启后新建的 ASMRClient 共用同一份记录
+var Jobs *jobs.Store
 
 func init() {
 	var err error
@@ -75,13 +51,81 @@ func init() {
 			fmt.Printf("Failed to set proxy: %v\n", err)
 		}
 	}
+
+	// 初始化 rclone RC 与缓存流控
+	utils.Rclone.URL = Conf.Rclone.APIUrl
+	utils.Rclone.User = Conf.Rclone.User
+	utils.Rclone.Pass = Conf.Rclone.Pass
+	utils.FlowControl.SetEnabled(Conf.Rclone.FlowControl)
+	utils.FlowControl.PauseThreshold = parseThreshold("pause_threshold", Conf.Rclone.PauseThreshold)
+	utils.FlowControl.ResumeThreshold = parseThreshold("resume_threshold", Conf.Rclone.ResumeThreshold)
+
+	// 接口与下载镜像
+	if len(Conf.APIHosts) > 0 {
+		APIHosts = NewHostPool(Conf.APIHosts)
+	}
+	// 没有单独配置下载镜像时，位于接口域名下的下载地址随接口镜像切换
+	MediaHosts = APIHosts
+	if len(Conf.MediaHosts) > 0 {
+		MediaHosts = NewHostPool(Conf.MediaHosts)
+	}
+
+	// 目录模板无效时退回默认值，避免作品落到意料之外的目录
+	if err := ValidateFolderTemplate(Conf.FolderTemplate); err != nil {
+		fmt.Printf("Invalid folder_template: %v\n", err)
+		Conf.FolderTemplate = DefaultFolderTemplate
+	}
+
+	// 初始化全局带宽限制
+	if err := utils.DownloadLimiter.SetSchedule(Conf.Bandwidth.Download); err != nil {
+		fmt.Printf("Invalid bwlimit.download: %v\n", err)
+	}
+	if err := utils.UploadLimiter.SetSchedule(Conf.Bandwidth.Upload); err != nil {
+		fmt.Printf("Invalid bwlimit.upload: %v\n", err)
+	}
+
+	// 初始化存储后端
+	Storage, err = utils.NewStorage(Conf.Storage.Type, Conf.Storage.Root)
+	if err != nil {
+		fmt.Printf("Failed to initialize storage: %v\n", err)
+		os.Exit(1)
+	}
+	// 只有写入挂载点时才经过 VFS 缓存
+	_, isMount := Storage.(*utils.MountStorage)
+	utils.Throttle.Enabled = Conf.Rclone.Adaptive && isMount
+	if Conf.Storage.TempDir != "" {
+		LocalTempDir = Conf.Storage.TempDir
+	}
+
+	Jobs, err = jobs.Open(JobStoreFile)
+	if err != nil {
+		fmt.Printf("Failed to open job store: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseThreshold 解析流控阈值，auto 或空值返回 0 表示自动计算
+func parseThreshold(name, value string) int64 {
+	if value == "" || strings.EqualFold(value, "auto") {
+		return 0
+	}
+	size, err := utils.ParseSize(value)
+	if err != nil {
+		fmt.Printf("Invalid rclone.%s %q, using auto: %v\n", name, value, err)
+		return 0
+	}
+	return size
 }
 
 type FailedTask struct {
-	URL       string
-	DirPath   string
-	FileName  string
-	RetryCount int
+	RJ         string `json:"rj"`
+	URL        string `json:"url"`
+	StreamURL  string `json:"stream_url,omitempty"`
+	DirPath    string `json:"dir_path"`
+	FileName   string `json:"file_name"`
+	RetryCount int    `json:"retry_count"`
+	// reported 已经提示过达到最大重试次数，之后的重试轮次不再重复提示
+	reported bool
 }
 
 type ASMRClient struct {
@@ -91,103 +135,72 @@ type ASMRClient struct {
 	FailedTasks   []FailedTask
 	MaxRetry      int
 	mu            sync.Mutex
+	// authMu 保护 Authorization，token 过期时只重新登录一次
+	authMu sync.Mutex
+	// ctx 结束时中断所有请求、下载和 rclone 缓存等待，之后不再接受新任务
+	ctx context.Context
 }
 
 type track struct {
 	Type             string  `json:"type"`
 	Title            string  `json:"title"`
 	Children         []track `json:"children,omitempty"`
+	// Hash 是形如 RJ123456/3 的曲目编号，并不是文件内容的校验和，校验见 utils/verify.go
 	Hash             string  `json:"hash,omitempty"`
 	WorkTitle        string  `json:"workTitle,omitempty"`
 	MediaStreamURL   string  `json:"mediaStreamUrl,omitempty"`
 	MediaDownloadURL string  `json:"mediaDownloadUrl,omitempty"`
+	// Size 文件大小，旧版接口不返回时为 0
+	Size int64 `json:"size,omitempty"`
 }
 
-func NewASMRClient(maxTask int, maxThread int, maxRetry int) *ASMRClient {
+func NewASMRClient(ctx context.Context, maxTask int, maxThread int, maxRetry int) *ASMRClient {
+	pool := utils.NewWorkerPool(maxTask)
+	pool.Storage = Storage
+	pool.Verify = Conf.Verify.Remote
+	pool.Checksum = Conf.Verify.Checksum
 	return &ASMRClient{
-		WorkerPool:  utils.NewWorkerPool(maxTask),
+		WorkerPool:  pool,
 		ThreadCount: maxThread,
 		FailedTasks: make([]FailedTask, 0),
 		MaxRetry:    maxRetry,
+		ctx:         ctx,
 	}
 }
 
-func (ac *ASMRClient) Login() error {
-	utils.GlobalMonitor.UpdateActivity()
-
-	payload, err := json.Marshal(map[string]string{
-		"name":     Conf.Account,
-		"password": Conf.Password,
-	})
-	if err != nil {
-		utils.Error(i18n.T("parse_error", err))
-		return err
-	}
-	client := utils.Client.Get().(*http.Client)
-	req, _ := http.NewRequest("POST", "https://api.asmr.one/api/auth/me", bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Referer", "https://www.asmr.one/")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36")
-	resp, err := client.Do(req)
-	utils.Client.Put(client)
-	if err != nil {
-		utils.Error(i18n.T("network_error", err))
-		return err
-	}
-	defer func() { _ = resp.Body.Close() }()
-	all, err := io.ReadAll(resp.Body)
-	if err != nil {
-		utils.Error(i18n.T("request_failed", err))
-		return err
-	}
-	res := make(map[string]string)
-	err = json.Unmarshal(all, &res)
-	ac.Authorization = "Bearer " + res["token"]
-	utils.GlobalMonitor.UpdateActivity()
-	utils.Success(i18n.T("login_success"))
-	return nil
-}
-
 func (ac *ASMRClient) GetVoiceTracks(id string) ([]track, error) {
-	utils.GlobalMonitor.UpdateActivity()
-
-	client := utils.Client.Get().(*http.Client)
-	req, _ := http.NewRequest("GET", "https://api.asmr.one/api/tracks/"+id, nil)
-	req.Header.Set("Authorization", ac.Authorization)
-	req.Header.Set("Referer", "https://www.asmr.one/")
-	req.Header.Set("User-Agent", "PostmanRuntime/7.29.0")
-	resp, err := client.Do(req)
-	utils.Client.Put(client)
+	all, err := ac.apiGet("/api/tracks/" + id)
 	if err != nil {
 		utils.Error(i18n.T("request_failed", err))
 		return nil, err
 	}
-	defer func() { _ = resp.Body.Close() }()
-	all, err := io.ReadAll(resp.Body)
-	if err != nil {
-		utils.Error(i18n.T("request_failed", err))
+	res := make([]track, 0)
+	if err := json.Unmarshal(all, &res); err != nil {
+		utils.Error(i18n.T("parse_error", err))
 		return nil, err
 	}
-	res := make([]track, 0)
-	err = json.Unmarshal(all, &res)
-	utils.GlobalMonitor.UpdateActivity()
 	return res, nil
 }
 
 // AddFailedTask 添加失败任务到重试队列
-func (ac *ASMRClient) AddFailedTask(url, dirPath, fileName string, retryCount int) {
+func (ac *ASMRClient) AddFailedTask(rj, url, streamURL, dirPath, fileName string, retryCount int) {
 	ac.mu.Lock()
 	defer ac.mu.Unlock()
 	ac.FailedTasks = append(ac.FailedTasks, FailedTask{
-		URL:       url,
-		DirPath:   dirPath,
-		FileName:  fileName,
+		RJ:         rj,
+		URL:        url,
+		StreamURL:  streamURL,
+		DirPath:    dirPath,
+		FileName:   fileName,
 		RetryCount: retryCount,
 	})
 }
 
 // RetryFailedTasks 重试所有失败的任务
 func (ac *ASMRClient) RetryFailedTasks() bool {
+	if ac.ctx.Err() != nil {
+		return false
+	}
 	ac.mu.Lock()
 	if len(ac.FailedTasks) == 0 {
 		ac.mu.Unlock()
@@ -205,12 +218,17 @@ func (ac *ASMRClient) RetryFailedTasks() bool {
 	retriedCount := 0
 	for _, task := range tasks {
 		if task.RetryCount >= ac.MaxRetry {
-			utils.Error(i18n.T("max_retry_reached", task.FileName))
+			if !task.reported {
+				utils.Error(i18n.T("max_retry_reached", task.FileName))
+				task.reported = true
+			}
 			permanentlyFailed = append(permanentlyFailed, task)
 			continue
 		}
 		utils.Info(i18n.T("retrying", task.RetryCount+1, ac.MaxRetry) + ": " + task.FileName)
-		ac.downloadFileWithRetry(task.URL, task.DirPath, task.FileName, task.RetryCount+1)
+		// 配置了下载镜像时，重试换到下一个镜像
+		ac.downloadFileWithRetry(task.RJ, MediaHosts.NextURL(task.URL), task.StreamURL, task.DirPath, task.FileName, task.RetryCount+1)
+		utils.CountRetry()
 		retriedCount++
 	}
 
@@ -226,68 +244,219 @@ func (ac *ASMRClient) RetryFailedTasks() bool {
 }
 
 func (ac *ASMRClient) Download(id string) {
-	id = strings.Replace(id, "RJ", "", 1)
-	utils.Info(i18n.T("fetching_work_info", "RJ"+id))
-	tracks, err := ac.GetVoiceTracks(id)
+	if ac.ctx.Err() != nil {
+		return
+	}
+	rj, err := NormalizeRJ(id)
 	if err != nil {
-		utils.Error(i18n.T("request_failed", err))
+		utils.Error(i18n.T("inputs_ignored", id))
+		return
+	}
+	id = strings.TrimPrefix(rj, "RJ")
+
+	// 文件列表已按相同过滤规则完整入库的作品直接按任务库恢复，不再请求 /api/tracks
+	// 命令行指定了过滤规则或规则已修改时重新获取文件列表，同时补上作品新增的文件
+	filterCfg, cli := filterConfigFor(rj)
+	key := filterKey(filterCfg)
+	work, enumerated := Jobs.GetWork(rj)
+	enumerated = enumerated && work.Enumerated
+	if enumerated && !cli && work.Filter == key {
+		basePath := work.BasePath
+		if basePath == "" {
+			basePath = rj
+		}
+		ac.saveMetadata(id, rj, basePath, nil)
+		ac.resumeJobs(rj)
+		return
+	}
+	if enumerated {
+		utils.Info(i18n.T("filter_replan", rj))
+	}
+
+	utils.Info(i18n.T("fetching_work_info", rj))
+	plan, err := ac.Plan(id, PlanOptions{})
+	if err != nil {
+		utils.Error(i18n.T("plan_failed", rj, err))
+		return
+	}
+	basePath := plan.BasePath
+	ac.saveMetadata(id, rj, basePath, plan.info)
+	if n := plan.Summary.Deduped.Files; n > 0 {
+		utils.Info(i18n.T("formats_deduped", rj, n))
+	}
+	if n := plan.Summary.Filtered.Files; n > 0 {
+		utils.Info(i18n.T("files_filtered", rj, n))
+	}
+	if enumerated {
+		ac.dropUnplannedJobs(rj, plan.Root)
+	}
+	ac.enqueuePlan(rj, plan.Root)
+	// 入队途中被中断时文件列表可能不完整，不标记入库，下次重新获取
+	if ac.ctx.Err() != nil {
 		return
 	}
-	basePath := "downloads/RJ" + id
-	ac.EnsureDir(tracks, basePath)
-	utils.Success(i18n.T("work_info_fetched", "RJ"+id))
+	if err := Jobs.MarkEnumerated(rj, basePath, key); err != nil {
+		utils.Warning(i18n.T("job_store_error", err))
+	}
+	utils.Success(i18n.T("work_info_fetched", rj))
 }
 
-func (ac *ASMRClient) downloadFileWithRetry(url string, dirPath string, fileName string, retryCount int) {
-	ac.downloadFileInternal(url, dirPath, fileName, retryCount)
+// saveMetadata 获取并保存作品元数据，info 为空时重新请求，失败时只打印警告，不影响音频下载
+// info.json 和封面分别检查，上次失败的封面会在下次运行时补齐
+func (ac *ASMRClient) saveMetadata(id, rj, basePath string, info *WorkInfo) {
+	if !Conf.Metadata {
+		return
+	}
+	var err error
+	if info == nil {
+		info, err = ac.GetWorkInfo(id)
+	}
+	if err == nil {
+		err = ac.SaveMetadata(rj, info, basePath)
+	}
+	if err != nil {
+		utils.Warning(i18n.T("metadata_failed", rj, err))
+	}
 }
 
-func (ac *ASMRClient) DownloadFile(url string, dirPath string, fileName string) {
-	ac.downloadFileInternal(url, dirPath, fileName, 0)
+// dropUnplannedJobs 重新获取文件列表后，将不再需要下载的未完成文件记为取消并删除临时文件
+func (ac *ASMRClient) dropUnplannedJobs(rj string, root *PlanNode) {
+	planned := make(map[string]bool)
+	collectPlanned(root, planned)
+	dropped := 0
+	for _, job := range Jobs.List(rj) {
+		if job.State == jobs.StateUploaded || job.State.Stopped() || planned[job.ID] {
+			continue
+		}
+		if job.TempPath != "" {
+			utils.RemovePartial(job.TempPath)
+		}
+		_ = Jobs.SetState(job.ID, jobs.StateCanceled, nil)
+		dropped++
+	}
+	if dropped > 0 {
+		utils.Info(i18n.T("filter_jobs_dropped", rj, dropped))
+	}
 }
 
-// 修改 downloadFileInternal 方法
-func (ac *ASMRClient) downloadFileInternal(url string, dirPath string, fileName string, retryCount int) {
+// collectPlanned 收集计划中需要下载的文件在任务库中的 ID
+func collectPlanned(node *PlanNode, planned map[string]bool) {
+	for _, child := range node.Children {
+		if child.Folder {
+			collectPlanned(child, planned)
+		} else if child.Action == PlanDownload {
+			planned[node.Path+"/"+localFileName(child.Name)] = true
+		}
+	}
+}
+
+// localFileName Windows 下替换文件名中不允许的字符
+func localFileName(name string) string {
 	if runtime.GOOS == "windows" {
 		for _, str := range []string{"?", "<", ">", ":", "/", "\\", "*", "|"} {
-			fileName = strings.Replace(fileName, str, "_", -1)
+			name = strings.Replace(name, str, "_", -1)
 		}
 	}
-	
-	// 最终保存路径 (Rclone 挂载路径)
+	return name
+}
+
+// resumeJobs 将任务库中尚未完成的文件重新加入队列（包括上次失败的文件，不包括手动暂停或取消的文件）
+// 重试次数沿用任务库中的记录，已达到最大重试次数的文件只放回失败列表，需用 ResumeJob 手动重试
+func (ac *ASMRClient) resumeJobs(rj string) {
+	pending := 0
+	for _, job := range Jobs.List(rj) {
+		if job.State == jobs.StateUploaded || job.State.Stopped() {
+			continue
+		}
+		retryCount := job.RetryCount
+		if job.State == jobs.StateFailed {
+			if retryCount >= ac.MaxRetry {
+				utils.Error(i18n.T("max_retry_reached", job.FileName))
+				ac.mu.Lock()
+				ac.FailedTasks = append(ac.FailedTasks, FailedTask{
+					RJ:         rj,
+					URL:        job.URL,
+					StreamURL:  job.StreamURL,
+					DirPath:    job.DirPath,
+					FileName:   job.FileName,
+					RetryCount: retryCount,
+					reported:   true,
+				})
+				ac.mu.Unlock()
+				continue
+			}
+			// 上次运行失败的文件，这次下载算一次重试
+			retryCount++
+		}
+		pending++
+		ac.downloadFileInternal(rj, job.URL, job.StreamURL, job.DirPath, job.FileName, retryCount)
+	}
+	utils.Info(i18n.T("jobs_resumed", rj, pending))
+}
+
+func (ac *ASMRClient) downloadFileWithRetry(rj string, url string, streamURL string, dirPath string, fileName string, retryCount int) {
+	ac.downloadFileInternal(rj, url, streamURL, dirPath, fileName, retryCount)
+}
+
+// DownloadFile streamURL 为 mediaStreamUrl，下载地址为空或返回 403 时改用它
+func (ac *ASMRClient) DownloadFile(rj string, url string, streamURL string, dirPath string, fileName string) {
+	ac.downloadFileInternal(rj, url, streamURL, dirPath, fileName, 0)
+}
+
+// 修改 downloadFileInternal 方法
+func (ac *ASMRClient) downloadFileInternal(rj string, url string, streamURL string, dirPath string, fileName string, retryCount int) {
+	if ac.ctx.Err() != nil {
+		return
+	}
+	if url == "" {
+		url = streamURL
+	}
+	// 所在镜像最近失败过时直接换到可用的镜像
+	url = MediaHosts.PreferredURL(url)
+	fileName = localFileName(fileName)
+
+	// 最终保存路径 (相对于存储根目录)
 	finalSavePath := dirPath + "/" + fileName
+	job, known := Jobs.Get(finalSavePath)
 
-	// 1. 检查最终目标是否存在 (逻辑不变)
+	// 1. 检查最终目标是否存在
 	headers := map[string]string{
 		"Referer": "https://www.asmr.one/",
 	}
 
-	if utils.PathExists(finalSavePath) {
-		localSize, err := utils.GetFileSize(finalSavePath)
-		if err != nil {
-			utils.Warning(i18n.T("file_error", err))
-		} else {
-			remoteSize, err := utils.GetRemoteFileSize(url, headers)
-			if err != nil {
-				utils.Warning(i18n.T("network_error", err))
-				utils.Info(i18n.T("file_exists", finalSavePath))
-				return
-			}
-			if localSize == remoteSize {
-				utils.Info(i18n.T("file_exists", finalSavePath))
-				return
-			} else {
-				utils.Warning(i18n.T("file_error", fmt.Sprintf("size mismatch: local=%d, remote=%d", localSize, remoteSize)))
+	if info, err := Storage.Stat(ac.ctx, finalSavePath); err == nil {
+		localSize := info.Size
+		if known && job.Size > 0 && job.Size == localSize {
+			// 任务库里已记录大小，无需再 HEAD
+			if job.State != jobs.StateUploaded {
+				_ = Jobs.SetState(finalSavePath, jobs.StateUploaded, nil)
 			}
+			utils.Info(i18n.T("file_exists", Storage.Location(finalSavePath)))
+			return
+		}
+		remoteSize, err := utils.GetRemoteFileSize(ac.ctx, url, headers)
+		if err != nil && streamURL != "" && streamURL != url {
+			remoteSize, err = utils.GetRemoteFileSize(ac.ctx, streamURL, headers)
+		}
+		if err != nil {
+			utils.Warning(i18n.T("network_error", err))
+			utils.Info(i18n.T("file_exists", Storage.Location(finalSavePath)))
+			return
 		}
+		if localSize == remoteSize {
+			ac.recordJob(rj, url, streamURL, dirPath, fileName, "", finalSavePath, remoteSize, jobs.StateUploaded, retryCount)
+			utils.Info(i18n.T("file_exists", Storage.Location(finalSavePath)))
+			return
+		}
+		utils.Warning(i18n.T("file_error", fmt.Sprintf("size mismatch: local=%d, remote=%d", localSize, remoteSize)))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		utils.Warning(i18n.T("file_error", err))
 	}
 
 	// 2. 构造本地临时路径
 	// 保持目录结构，避免文件名冲突
 	// 例如: /root/asmr_temp/RJ123456/sound.wav
-	relDir := strings.TrimPrefix(dirPath, "downloads/") // 假设 base 是 downloads
-	tempDir := filepath.Join(LocalTempDir, relDir)
-	_ = os.MkdirAll(tempDir, 0755)
+	tempDir := filepath.Join(LocalTempDir, filepath.FromSlash(dirPath))
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
 		utils.Error("Failed to create temp dir: %v", err)
 		return 
@@ -298,17 +467,94 @@ func (ac *ASMRClient) downloadFileInternal(url string, dirPath string, fileName
 
 	// 3. 修改 Downloader 初始化，下载到 tempFullPath
 	// 注意：这里传入 tempDir 和 fileName
-	downloader := utils.NewDownloader(url, tempDir, fileName, ac.ThreadCount, headers)
+	downloader := utils.NewDownloader(ac.ctx, url, tempDir, fileName, ac.Threads(), headers)
 	downloader.FinalPath = finalSavePath
 	downloader.RetryCount = retryCount
+	downloader.FallbackURL = streamURL
+	downloader.Failover = MediaHosts.Failover
+
+	// 上次已下载完成但未移动成功的文件，大小一致时直接复用临时文件
+	size := int64(0)
+	if known && job.Size > 0 {
+		size = job.Size
+		downloader.ContentLength = size
+	}
+	ac.recordJob(rj, url, streamURL, dirPath, fileName, tempFullPath, finalSavePath, size, jobs.StateQueued, retryCount)
+
+	downloader.OnStart = func() {
+		_ = Jobs.SetState(finalSavePath, jobs.StateDownloading, nil)
+	}
+	downloader.OnFallback = func() {
+		_ = Jobs.Update(finalSavePath, func(j *jobs.Job) {
+			j.URL = streamURL
+			j.Source = jobs.SourceStream
+			j.Size = 0
+		})
+	}
+	downloader.OnFailover = func() {
+		_ = Jobs.Update(finalSavePath, func(j *jobs.Job) {
+			j.URL = downloader.Url
+		})
+	}
+	downloader.OnStaged = func() {
+		_ = Jobs.Update(finalSavePath, func(j *jobs.Job) {
+			j.State = jobs.StateStaged
+			j.Error = ""
+			if size, err := utils.GetFileSize(tempFullPath); err == nil {
+				j.Size = size
+			}
+		})
+	}
+	downloader.OnSuccess = func() {
+		_ = Jobs.Update(finalSavePath, func(j *jobs.Job) {
+			j.State = jobs.StateUploaded
+			j.Error = ""
+			if downloader.Checksum != "" {
+				j.Checksum = Conf.Verify.Checksum + ":" + downloader.Checksum
+			}
+		})
+	}
 	
 	// 这里需要拦截 Downloader 的 OnFailure，如果下载失败不移动
 	originalFailure := downloader.OnFailure
 	downloader.OnFailure = func(failedUrl, failedPath, failedName string, err error) {
-		// 失败时，删除临时文件
-		os.Remove(tempFullPath)
+		// 手动暂停保留续传进度，取消则删除临时文件，两者都不加入重试
+		if errors.Is(err, utils.ErrPaused) || errors.Is(err, utils.ErrCanceled) {
+			state := jobs.StatePaused
+			if errors.Is(err, utils.ErrCanceled) {
+				state = jobs.StateCanceled
+				utils.RemovePartial(tempFullPath)
+			}
+			_ = Jobs.SetState(finalSavePath, state, nil)
+			return
+		}
+		// 整个客户端被中断（超时重启、Ctrl+C），保留续传进度，下载中的文件记为排队，下次 resume 时继续
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			_ = Jobs.Update(finalSavePath, func(j *jobs.Job) {
+				if j.State == jobs.StateDownloading {
+					j.State = jobs.StateQueued
+				}
+			})
+			return
+		}
+		// 失败时删除临时文件，但保留可续传的部分和已下载完成待移动的文件
+		moveFailed := errors.Is(err, utils.ErrMoveFailed)
+		if !downloader.Resumable() && !moveFailed {
+			os.Remove(tempFullPath)
+		}
+		_ = Jobs.Update(finalSavePath, func(j *jobs.Job) {
+			j.State = jobs.StateFailed
+			j.Error = err.Error()
+			j.RetryCount = retryCount
+			if moveFailed {
+				// 临时文件已经完整，记住大小以便重试时跳过下载
+				if size, statErr := utils.GetFileSize(tempFullPath); statErr == nil {
+					j.Size = size
+				}
+			}
+		})
 		if ac.FailedTasks != nil { // 确保 ac.AddFailedTask 可用
-             ac.AddFailedTask(failedUrl, dirPath, failedName, retryCount) // 注意这里存回原始 dirPath
+             ac.AddFailedTask(rj, failedUrl, streamURL, dirPath, failedName, retryCount) // 注意这里存回原始 dirPath
         }
         // 调用原始逻辑（如果有）
         if originalFailure != nil {
@@ -316,27 +562,30 @@ func (ac *ASMRClient) downloadFileInternal(url string, dirPath string, fileName
         }
 	}
 
-	// 我们需要包装一下 TaskQueue 的处理逻辑，
-    // 因为 Downloader 是在 WorkerPool 里异步执行的，
-    // 我们无法直接在这里写 moveFile。
-    
-    // **最佳修改方案**：
-    // 不改 WorkerPool，而是利用 Downloader 成功后的回调机制。
-    // 但是现在的 Downloader 没有 Success 回调。
-    // 我们可以在 downloader.go 中增加 OnSuccess，或者简单一点：
-    // 修改 worker.go 的逻辑（见下文）。
-    
-	ac.WorkerPool.TaskQueue <- downloader
+	ac.WorkerPool.Submit(downloader)
 }
 
-func (ac *ASMRClient) EnsureDir(tracks []track, basePath string) {
-	path := basePath
-	_ = os.MkdirAll(path, os.ModePerm)
-	for _, t := range tracks {
-		if t.Type != "folder" {
-			ac.DownloadFile(t.MediaDownloadURL, path, t.Title)
-		} else {
-			ac.EnsureDir(t.Children, path+"/"+t.Title)
-		}
+// recordJob 写入或覆盖文件记录
+func (ac *ASMRClient) recordJob(rj, url, streamURL, dirPath, fileName, tempPath, finalPath string, size int64, state jobs.State, retryCount int) {
+	source := jobs.SourceDownload
+	if streamURL != "" && url == streamURL {
+		source = jobs.SourceStream
+	}
+	err := Jobs.Put(jobs.Job{
+		ID:         finalPath,
+		RJ:         rj,
+		URL:        url,
+		StreamURL:  streamURL,
+		Source:     source,
+		DirPath:    dirPath,
+		FileName:   fileName,
+		TempPath:   tempPath,
+		FinalPath:  finalPath,
+		Size:       size,
+		State:      state,
+		RetryCount: retryCount,
+	})
+	if err != nil {
+		utils.Warning(i18n.T("job_store_error", err))
 	}
 }
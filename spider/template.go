@@ -0,0 +1,152 @@
+package spider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultFolderTemplate 默认目录模板，与旧版本的 RJ 号目录一致
+const DefaultFolderTemplate = "{rj}"
+
+// 单个字段的最大长度（字符），避免超过文件系统的文件名长度限制
+const maxFieldLength = 80
+
+var templateField = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// 模板中只依赖 RJ 号的字段，不需要请求作品信息
+var offlineFields = map[string]bool{"rj": true, "id": true}
+
+// templateNeedsInfo 模板是否需要 /api/workInfo 中的字段
+func templateNeedsInfo(tmpl string) bool {
+	for _, m := range templateField.FindAllStringSubmatch(tmpl, -1) {
+		if !offlineFields[m[1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFolderTemplate 检查模板中的字段名
+func ValidateFolderTemplate(tmpl string) error {
+	if strings.TrimSpace(tmpl) == "" {
+		return fmt.Errorf("folder_template is empty")
+	}
+	for _, m := range templateField.FindAllStringSubmatch(tmpl, -1) {
+		if _, err := templateValue(m[1], "RJ000000", &WorkInfo{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderFolder 按模板生成作品目录（相对于存储根目录，以 / 分隔）
+// 例如 {circle}/{rj} {title}，每个字段单独清理非法字符，模板中的 / 作为目录分隔
+func RenderFolder(tmpl string, rj string, info *WorkInfo) (string, error) {
+	if info == nil {
+		info = &WorkInfo{}
+	}
+	var renderErr error
+	rendered := templateField.ReplaceAllStringFunc(tmpl, func(field string) string {
+		value, err := templateValue(field[1:len(field)-1], rj, info)
+		if err != nil {
+			renderErr = err
+			return ""
+		}
+		return SanitizeName(value)
+	})
+	if renderErr != nil {
+		return "", renderErr
+	}
+
+	parts := make([]string, 0)
+	for _, part := range strings.Split(rendered, "/") {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return rj, nil
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+func templateValue(field, rj string, info *WorkInfo) (string, error) {
+	switch field {
+	case "rj":
+		return rj, nil
+	case "id":
+		return strings.TrimPrefix(rj, "RJ"), nil
+	case "title":
+		return orUnknown(info.Title), nil
+	case "circle":
+		return orUnknown(info.Circle.Name), nil
+	case "va":
+		if len(info.VAs) > 0 {
+			return orUnknown(info.VAs[0].Name), nil
+		}
+		return "unknown", nil
+	case "vas":
+		names := make([]string, 0, len(info.VAs))
+		for _, va := range info.VAs {
+			names = append(names, va.Name)
+		}
+		return orUnknown(strings.Join(names, ", ")), nil
+	case "release":
+		return orUnknown(info.Release), nil
+	case "release_year":
+		if len(info.Release) >= 4 {
+			return info.Release[:4], nil
+		}
+		return "unknown", nil
+	default:
+		return "", fmt.Errorf("unknown folder template field: {%s}", field)
+	}
+}
+
+func orUnknown(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// SanitizeName 替换 Windows/网盘不允许的字符，去掉首尾空格和结尾的点，并限制长度
+func SanitizeName(name string) string {
+	name = strings.TrimSpace(replaceInvalid(name))
+	if utf8.RuneCountInString(name) > maxFieldLength {
+		name = string([]rune(name)[:maxFieldLength])
+	}
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// sanitizeTitle 清理接口返回的文件或文件夹名，作为路径中的一段使用
+// 与 SanitizeName 相同但不截断，避免丢掉扩展名；. 和 .. 会变成 _
+func sanitizeTitle(title string) string {
+	title = strings.TrimRight(strings.TrimSpace(replaceInvalid(title)), ". ")
+	if title == "" {
+		return "_"
+	}
+	return title
+}
+
+// replaceInvalid 将路径分隔符和文件系统保留字符替换为 _，删除控制字符
+func replaceInvalid(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,104 @@
+package spider
+
+import (
+	"strings"
+	"testing"
+)
+
+func workInfo(title, circle, release string, vas ...string) *WorkInfo {
+	info := &WorkInfo{Title: title, Release: release}
+	info.Circle.Name = circle
+	for _, name := range vas {
+		info.VAs = append(info.VAs, struct {
+			Name string `json:"name"`
+		}{name})
+	}
+	return info
+}
+
+func TestRenderFolder(t *testing.T) {
+	full := workInfo("耳かき/添い寝", "Circle: A", "2023-05-01", "VA1", "VA2")
+	tests := []struct {
+		name    string
+		tmpl    string
+		info    *WorkInfo
+		want    string
+		wantErr bool
+	}{
+		{"default", DefaultFolderTemplate, nil, "RJ123456", false},
+		{"id", "{id}", nil, "123456", false},
+		{"fields", "{circle}/{rj} {title}", full, "Circle_ A/RJ123456 耳かき_添い寝", false},
+		{"vas and year", "{va}/{release_year}/{vas}", full, "VA1/2023/VA1, VA2", false},
+		{"missing fields", "{circle}/{va}/{release_year}/{rj}", nil, "unknown/unknown/unknown/RJ123456", false},
+		{"blank title", "{rj} {title}", workInfo("  ", "", ""), "RJ123456 unknown", false},
+		{"dot segments dropped", "../{rj}/./", nil, "RJ123456", false},
+		{"field cannot climb", "{title}/{rj}", workInfo("..", "", ""), "_/RJ123456", false},
+		{"empty result falls back to rj", " / ", nil, "RJ123456", false},
+		{"unknown field", "{rj}/{foo}", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := RenderFolder(tt.tmpl, "RJ123456", tt.info)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: RenderFolder(%q) = %q, %v; want %q, error %v", tt.name, tt.tmpl, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{"a/b\\c", "a_b_c"},
+		{`what?*"<>|:`, "what_______"},
+		{"  spaced  ", "spaced"},
+		{"trailing dots...", "trailing dots"},
+		{"tab\there\x7f", "tabhere"},
+		{"", "_"},
+		{" . ", "_"},
+		{"..", "_"},
+		{strings.Repeat("あ", 100), strings.Repeat("あ", maxFieldLength)},
+		{strings.Repeat("a", maxFieldLength-1) + " b", strings.Repeat("a", maxFieldLength-1)},
+	}
+	for _, tt := range tests {
+		if got := SanitizeName(tt.in); got != tt.want {
+			t.Errorf("SanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeTitle(t *testing.T) {
+	long := strings.Repeat("あ", 100) + ".wav"
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"01 Intro.wav", "01 Intro.wav"},
+		{"SE/なし", "SE_なし"},
+		{"..", "_"},
+		{".", "_"},
+		{"bonus. ", "bonus"},
+		{"", "_"},
+		{long, long},
+	}
+	for _, tt := range tests {
+		if got := sanitizeTitle(tt.in); got != tt.want {
+			t.Errorf("sanitizeTitle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCollectPathsSanitizesTitles(t *testing.T) {
+	tracks := []track{
+		folder("../SE/なし", file("01.wav")),
+		folder("mp3.", file("a/b.mp3")),
+	}
+	got := make(map[string]bool)
+	collectPaths(tracks, "RJ123456", got)
+	for _, want := range []string{"RJ123456/.._SE_なし/01.wav", "RJ123456/mp3/a_b.mp3"} {
+		if !got[want] {
+			t.Errorf("collectPaths missing %q, got %v", want, got)
+		}
+	}
+}
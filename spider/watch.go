@@ -0,0 +1,63 @@
+package spider
+
+import (
+	"strings"
+	"time"
+
+	"re-asmr-spider/config"
+	"re-asmr-spider/i18n"
+	"re-asmr-spider/utils"
+)
+
+// Downloaded 作品是否已经下载过（文件列表已写入任务库）
+// 未完成的文件由 resume 负责，sync 不再重复加入
+func Downloaded(rj string) bool {
+	_, ok := Jobs.GetWork(strings.ToUpper(rj))
+	return ok
+}
+
+// NewReleases 逐个查询关注的社团和声优，返回尚未下载过的作品（按 RJ 去重）
+// 单个查询失败只打印错误并继续，failed 为失败的查询数
+func (ac *ASMRClient) NewReleases(watch config.WatchConfig) (works []WorkInfo, failed int, err error) {
+	var since time.Time
+	if watch.Since != "" {
+		if since, err = time.Parse(SearchDateLayout, watch.Since); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	queries := make([]SearchOptions, 0, len(watch.Circles)+len(watch.VAs))
+	for _, c := range watch.Circles {
+		queries = append(queries, SearchOptions{Circles: []string{c}, Since: since})
+	}
+	for _, va := range watch.VAs {
+		queries = append(queries, SearchOptions{VAs: []string{va}, Since: since})
+	}
+
+	works = make([]WorkInfo, 0)
+	seen := make(map[string]bool)
+	for _, q := range queries {
+		if q.Query() == "" {
+			continue
+		}
+		utils.Info(i18n.T("sync_checking", q.Query()))
+		found, _, err := ac.Search(q)
+		if err != nil {
+			utils.Error(i18n.T("search_failed", err))
+			failed++
+			continue
+		}
+		count := 0
+		for _, w := range found {
+			rj := w.RJ()
+			if seen[rj] || Downloaded(rj) {
+				continue
+			}
+			seen[rj] = true
+			works = append(works, w)
+			count++
+		}
+		utils.Info(i18n.T("sync_found", len(found), count))
+	}
+	return works, failed, nil
+}
@@ -2,32 +2,35 @@ package utils
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"sync"
 	"time"
+
+	"re-asmr-spider/i18n"
 )
 
 var (
 	defaultUA                    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36"
 	ErrUnsupportedMultiThreading = errors.New("unsupported multi-threading")
+	// ErrPaused/ErrCanceled 下载被手动暂停或取消，不计入失败重试
+	ErrPaused   = errors.New("paused")
+	ErrCanceled = errors.New("canceled")
 	// 缓冲区维持 4MB
 	bufferSize = 8 * 1024 * 1024
-	
-	// 🔥 【新增】下载限速设置
-	// 设置为 20MB/s (20 * 1024 * 1024)
-	// 如果你的 Rclone 上传能稳定 30MB/s，可以改大；如果只有 10MB/s，请改小。
-	// 目的：防止下载太快填满 Rclone 缓存导致程序假死。
-	SpeedLimit = 50 * 1024 * 1024
 )
 
 type BlockMetaData struct {
+	StartOffset    int64 // 分块起始位置，不随下载推进
 	BeginOffset    int64
 	EndOffset      int64
 	DownloadedSize int64
+	committed      int64 // 已 Flush 到文件的位置，写入进度日志
 }
 
 type MultiThreadDownloader struct {
@@ -42,7 +45,48 @@ type MultiThreadDownloader struct {
 	ThreadCount int
 	ProgressBar *ProgressBar
 	OnFailure   func(url, savePath, fileName string, err error)
+	OnStart     func() // 开始下载到临时目录
+	OnStaged    func() // 临时文件下载完成
+	OnSuccess   func() // 已移动到最终路径
 	RetryCount  int
+
+	// FallbackURL 原地址返回 403 时改用的备用地址（mediaStreamUrl），为空时不切换
+	FallbackURL string
+	// OnFallback 切换到备用地址时调用
+	OnFallback func()
+	// Failover 连接失败或返回 5xx 时返回换到其他镜像的地址，为空或返回空字符串时不切换
+	Failover func(url string) string
+	// OnFailover 切换到其他镜像后调用，此时 Url 已是新地址
+	OnFailover func()
+
+	// ContentLength 文件总大小，未知时为 0
+	ContentLength int64
+	// Checksum 临时文件的校验和，WorkerPool 开启校验时填写
+	Checksum    string
+	journalMu   sync.Mutex
+	lastJournal time.Time
+
+	// ctx 取消时中断正在进行的请求，stopErr 记录暂停还是取消
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopMu  sync.Mutex
+	stopErr error
+	// progress 最近一次落盘时的进度，由 journalMu 保护
+	progress *DownloadProgress
+}
+
+// BlockProgress 单个分块已落盘的字节数
+type BlockProgress struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+// DownloadProgress 下载进度快照
+type DownloadProgress struct {
+	Total  int64           `json:"total"`
+	Done   int64           `json:"done"`
+	Blocks []BlockProgress `json:"blocks"`
 }
 
 // progressWriter 封装 io.Writer 以更新进度条
@@ -59,42 +103,17 @@ func (pw *progressWriter) Write(p []byte) (int, error) {
 	return n, err
 }
 
-// 🔥 【新增】限速读取器
-// 通过在 Read 操作中增加延时来实现限速
-type RateLimitedReader struct {
-	r     io.Reader
-	start time.Time
-}
-
-func (r *RateLimitedReader) Read(p []byte) (int, error) {
-	// 记录开始时间
-	start := time.Now()
-	
-	n, err := r.r.Read(p)
-	
-	if n > 0 && SpeedLimit > 0 {
-		// 计算读取这些数据理论上需要的最少时间
-		// 期望耗时 = 数据量 / 限制速度
-		expectedDuration := time.Duration(float64(n) / float64(SpeedLimit) * float64(time.Second))
-		
-		// 实际耗时
-		elapsed := time.Since(start)
-		
-		// 如果读得太快（实际耗时 < 期望耗时），就睡一会儿
-		if elapsed < expectedDuration {
-			time.Sleep(expectedDuration - elapsed)
-		}
-	}
-	return n, err
-}
-
-func NewDownloader(url string, path string, name string, threadCount int, headers map[string]string) *MultiThreadDownloader {
+// NewDownloader ctx 结束时中断所有分块的请求，下载返回 ctx.Err()
+func NewDownloader(ctx context.Context, url string, path string, name string, threadCount int, headers map[string]string) *MultiThreadDownloader {
 	// 修复超时问题：复制 Client 并移除超时限制
 	globalClient := Client.Get().(*http.Client)
 	downloadClient := *globalClient
 	downloadClient.Timeout = 0 // 设置为 0，防止大文件下载超时
 
+	ctx, cancel := context.WithCancel(ctx)
 	return &MultiThreadDownloader{
+		ctx:         ctx,
+		cancel:      cancel,
 		Url:         url,
 		SavePath:    path,
 		FileName:    name,
@@ -106,24 +125,189 @@ func NewDownloader(url string, path string, name string, threadCount int, header
 	}
 }
 
+// StatusError 服务器返回了非 2xx 状态码
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return "response status unsuccessful: " + strconv.Itoa(e.Code)
+}
+
+// IsForbidden 错误是否为 403
+func IsForbidden(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Code == http.StatusForbidden
+}
+
+// IsHostError 错误是否由主机本身引起（连接失败、超时、5xx 或 429），换一个镜像可能成功
+func IsHostError(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500 || se.Code == http.StatusTooManyRequests
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// Download 下载到临时文件，原地址 403 时换到 FallbackURL 从头再下载一次
 func (m *MultiThreadDownloader) Download() error {
+	err := m.downloadWithFailover()
+	if reason := m.StopReason(); reason != nil {
+		return reason
+	}
+	if err == nil || m.FallbackURL == "" || m.FallbackURL == m.Url || !IsForbidden(err) {
+		return err
+	}
+	Warning(i18n.T("download_fallback", m.FileName, err))
+	m.setURL(m.FallbackURL)
+	m.FallbackURL = ""
+	// 两个地址的内容不一定相同，丢弃原地址的续传进度
+	m.discardProgress()
+	m.Blocks = nil
+	m.ContentLength = 0
+	if m.OnFallback != nil {
+		m.OnFallback()
+	}
+	err = m.downloadWithFailover()
+	if reason := m.StopReason(); reason != nil {
+		return reason
+	}
+	return err
+}
+
+// downloadWithFailover 主机出错时立即换到其他镜像继续下载，每个地址只尝试一次
+// 各镜像上的文件相同，已下载的部分按进度日志续传
+func (m *MultiThreadDownloader) downloadWithFailover() error {
+	tried := map[string]bool{m.Url: true}
+	for {
+		err := m.download()
+		if err == nil || m.Failover == nil || m.StopReason() != nil || !IsHostError(err) {
+			return err
+		}
+		next := m.Failover(m.Url)
+		if next == "" || tried[next] {
+			return err
+		}
+		tried[next] = true
+		Warning(i18n.T("media_host_failover", m.FileName, err, next))
+		m.setURL(next)
+		m.Blocks = nil
+		if m.OnFailover != nil {
+			m.OnFailover()
+		}
+	}
+}
+
+// setURL 切换下载地址，Url 只在下载所在的 goroutine 中修改，其他 goroutine 通过 CurrentURL 读取
+func (m *MultiThreadDownloader) setURL(url string) {
+	m.stopMu.Lock()
+	defer m.stopMu.Unlock()
+	m.Url = url
+}
+
+// CurrentURL 当前使用的下载地址，切换到备用地址或其他镜像后随之变化
+func (m *MultiThreadDownloader) CurrentURL() string {
+	m.stopMu.Lock()
+	defer m.stopMu.Unlock()
+	return m.Url
+}
+
+// Stop 中断下载，reason 为 ErrPaused 或 ErrCanceled，只有第一次调用生效
+func (m *MultiThreadDownloader) Stop(reason error) {
+	m.stopMu.Lock()
+	if m.stopErr == nil {
+		m.stopErr = reason
+	}
+	m.stopMu.Unlock()
+	m.cancel()
+}
+
+// StopReason 下载被 Stop 时返回原因，创建时传入的 ctx 结束时返回 ctx.Err()，否则返回 nil
+func (m *MultiThreadDownloader) StopReason() error {
+	m.stopMu.Lock()
+	defer m.stopMu.Unlock()
+	if m.stopErr != nil {
+		return m.stopErr
+	}
+	return m.ctx.Err()
+}
+
+// Progress 各分块最近一次落盘时的进度快照，每个分块约 2 秒更新一次
+// 服务器不支持 Range（不分块）或尚未开始时返回 nil
+func (m *MultiThreadDownloader) Progress() *DownloadProgress {
+	m.journalMu.Lock()
+	defer m.journalMu.Unlock()
+	if m.progress == nil {
+		return nil
+	}
+	p := *m.progress
+	p.Blocks = append([]BlockProgress(nil), m.progress.Blocks...)
+	return &p
+}
+
+func (m *MultiThreadDownloader) download() error {
+	// 上次已完整下载但未能移动的临时文件，直接复用
+	if m.ContentLength > 0 && !PathExists(m.journalPath()) {
+		if size, err := GetFileSize(m.FullPath); err == nil && size == m.ContentLength {
+			return nil
+		}
+	}
+	// 有进度日志时只请求缺失的区间
+	if m.loadProgress() {
+		Info(i18n.T("download_resuming", m.FileName, float64(m.downloadedSize())*100/float64(m.ContentLength)))
+		m.ProgressBar = NewProgressBar(m.ContentLength, m.FileName)
+		m.ProgressBar.Add(m.downloadedSize())
+		return m.finishDownload(m.downloadAllBlocks())
+	}
 	if m.ThreadCount < 2 {
-		return m.singleThreadDownload()
+		return m.finishDownload(m.singleThreadDownload())
 	}
 	if err := m.initDownload(); err != nil {
 		if err == ErrUnsupportedMultiThreading {
-			return m.singleThreadDownload()
+			return m.finishDownload(m.singleThreadDownload())
 		}
 		return err
 	}
+	return m.finishDownload(m.downloadAllBlocks())
+}
+
+// finishDownload 成功时删除进度日志，续传记录失效时同样删除以便下次从头开始
+func (m *MultiThreadDownloader) finishDownload(err error) error {
+	if err == nil {
+		err = m.verifySize()
+	}
+	if err == nil || errors.Is(err, ErrJournalMismatch) {
+		m.discardProgress()
+	}
+	return err
+}
+
+func (m *MultiThreadDownloader) downloadedSize() int64 {
+	var size int64
+	for _, b := range m.Blocks {
+		size += b.DownloadedSize
+	}
+	return size
+}
+
+func (m *MultiThreadDownloader) downloadAllBlocks() error {
 	wg := sync.WaitGroup{}
 	wg.Add(len(m.Blocks))
 	var lastErr error
+	var errMu sync.Mutex
 	for i := range m.Blocks {
+		if m.Blocks[i].BeginOffset > m.Blocks[i].EndOffset {
+			// 续传时已完成的分块
+			wg.Done()
+			continue
+		}
 		go func(b *BlockMetaData) {
 			defer wg.Done()
 			if err := m.downloadBlocks(b); err != nil {
+				errMu.Lock()
 				lastErr = err
+				errMu.Unlock()
 			}
 		}(m.Blocks[i])
 	}
@@ -153,8 +337,8 @@ func (m *MultiThreadDownloader) initDownload() error {
 
 		pw := &progressWriter{w: writer, bar: m.ProgressBar}
 		
-		// 🔥 使用限速读取器包裹 Body
-		limiter := &RateLimitedReader{r: s}
+		// 🔥 使用全局限速器包裹 Body
+		limiter := &limitedReader{ctx: m.ctx, r: s, limiter: DownloadLimiter}
 
 		buf := make([]byte, bufferSize)
 		_, err = io.CopyBuffer(pw, limiter, buf)
@@ -168,7 +352,7 @@ func (m *MultiThreadDownloader) initDownload() error {
 		return ErrUnsupportedMultiThreading
 	}
 
-	req, err := http.NewRequest("GET", m.Url, nil)
+	req, err := http.NewRequestWithContext(m.ctx, "GET", m.Url, nil)
 	if err != nil {
 		return err
 	}
@@ -187,7 +371,7 @@ func (m *MultiThreadDownloader) initDownload() error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.New("response status unsuccessful: " + strconv.FormatInt(int64(resp.StatusCode), 10))
+		return &StatusError{Code: resp.StatusCode}
 	}
 
 	if resp.StatusCode == 200 {
@@ -196,6 +380,7 @@ func (m *MultiThreadDownloader) initDownload() error {
 
 	if resp.StatusCode == 206 {
 		contentLength = resp.ContentLength
+		m.ContentLength = contentLength
 		if contentLength > 0 {
 			m.ProgressBar = NewProgressBar(contentLength, m.FileName)
 		}
@@ -214,22 +399,47 @@ func (m *MultiThreadDownloader) initDownload() error {
 		var tmp int64
 		for tmp+blockSize < contentLength {
 			m.Blocks = append(m.Blocks, &BlockMetaData{
+				StartOffset: tmp,
 				BeginOffset: tmp,
 				EndOffset:   tmp + blockSize - 1,
+				committed:   tmp,
 			})
 			tmp += blockSize
 		}
 		m.Blocks = append(m.Blocks, &BlockMetaData{
+			StartOffset: tmp,
 			BeginOffset: tmp,
 			EndOffset:   contentLength - 1,
+			committed:   tmp,
 		})
+
+		// 预先分配文件大小，清掉旧的残留内容，再记录初始进度
+		if err := m.prepareFile(contentLength); err != nil {
+			return err
+		}
 		return nil
 	}
 	return errors.New("unknown status code")
 }
 
+// prepareFile 创建指定大小的临时文件并写入初始进度日志
+func (m *MultiThreadDownloader) prepareFile(size int64) error {
+	file, err := os.OpenFile(m.FullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return m.saveProgress()
+}
+
 func (m *MultiThreadDownloader) downloadBlocks(block *BlockMetaData) error {
-	req, _ := http.NewRequest("GET", m.Url, nil)
+	req, _ := http.NewRequestWithContext(m.ctx, "GET", m.Url, nil)
 	file, err := os.OpenFile(m.FullPath, os.O_WRONLY, 0666)
 	if err != nil {
 		file, err = os.OpenFile(m.FullPath, os.O_WRONLY|os.O_CREATE, 0666)
@@ -244,7 +454,12 @@ func (m *MultiThreadDownloader) downloadBlocks(block *BlockMetaData) error {
 	}
 	
 	writer := bufio.NewWriterSize(file, bufferSize)
-	defer writer.Flush()
+	// 无论成功失败，都把缓冲区里的数据落盘并记录进度，下次从这里续传
+	defer func() {
+		if writer.Flush() == nil {
+			m.commitBlock(block, true)
+		}
+	}()
 
 	for k, v := range m.Headers {
 		req.Header.Set(k, v)
@@ -261,25 +476,26 @@ func (m *MultiThreadDownloader) downloadBlocks(block *BlockMetaData) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.New("response status unsuccessful: " + strconv.FormatInt(int64(resp.StatusCode), 10))
+		return &StatusError{Code: resp.StatusCode}
+	}
+	// 服务器忽略 Range 或文件已变化时，已下载的部分不能再用
+	if resp.StatusCode != http.StatusPartialContent {
+		return ErrJournalMismatch
+	}
+	if total, ok := contentRangeTotal(resp.Header.Get("Content-Range")); ok && m.ContentLength > 0 && total != m.ContentLength {
+		return ErrJournalMismatch
 	}
 
 	buffer := make([]byte, bufferSize)
+	lastFlush := time.Now()
 	
-	// 🔥 仅在循环内部通过 Sleep 简单控制，不复用 Reader 以简化 Seek 逻辑
+	// 🔥 所有分块共享全局限速器
 	for {
-		// 记录开始时间
-		start := time.Now()
-		
 		n, readErr := resp.Body.Read(buffer)
 		if n > 0 {
 			// 1. 先进行限速控制
-			if SpeedLimit > 0 {
-				expectedDuration := time.Duration(float64(n) / float64(SpeedLimit) * float64(time.Second))
-				elapsed := time.Since(start)
-				if elapsed < expectedDuration {
-					time.Sleep(expectedDuration - elapsed)
-				}
+			if err := DownloadLimiter.WaitN(m.ctx, n); err != nil {
+				return err
 			}
 
 			// 2. 再处理写入逻辑
@@ -303,6 +519,15 @@ func (m *MultiThreadDownloader) downloadBlocks(block *BlockMetaData) error {
 			if block.BeginOffset > block.EndOffset {
 				break
 			}
+
+			// 定期落盘并更新进度日志
+			if time.Since(lastFlush) >= journalInterval {
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+				m.commitBlock(block, false)
+				lastFlush = time.Now()
+			}
 		}
 		
 		if readErr == io.EOF {
@@ -323,9 +548,8 @@ func (m *MultiThreadDownloader) singleThreadDownload() error {
 	defer file.Close()
 
 	writer := bufio.NewWriterSize(file, bufferSize)
-	defer writer.Flush()
 
-	req, err := http.NewRequest("GET", m.Url, nil)
+	req, err := http.NewRequestWithContext(m.ctx, "GET", m.Url, nil)
 	if err != nil {
 		return err
 	}
@@ -343,16 +567,40 @@ func (m *MultiThreadDownloader) singleThreadDownload() error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{Code: resp.StatusCode}
+	}
+
 	if resp.ContentLength > 0 {
+		m.ContentLength = resp.ContentLength
 		m.ProgressBar = NewProgressBar(resp.ContentLength, m.FileName)
 	}
 
-	pw := &progressWriter{w: writer, bar: m.ProgressBar}
-	// 🔥 使用限速 Reader
-	limiter := &RateLimitedReader{r: resp.Body}
+	var dst io.Writer = &progressWriter{w: writer, bar: m.ProgressBar}
+
+	// 服务器支持 Range 时按单个分块记录进度，中断后可以续传
+	if resp.ContentLength > 0 && resp.Header.Get("Accept-Ranges") == "bytes" {
+		block := &BlockMetaData{EndOffset: resp.ContentLength - 1}
+		m.Blocks = []*BlockMetaData{block}
+		if err := file.Truncate(resp.ContentLength); err != nil {
+			return err
+		}
+		if err := m.saveProgress(); err != nil {
+			return err
+		}
+		dst = &journalWriter{m: m, buf: writer, block: block, next: dst, lastFlush: time.Now()}
+	}
+	defer func() {
+		if writer.Flush() == nil && len(m.Blocks) == 1 {
+			m.commitBlock(m.Blocks[0], true)
+		}
+	}()
+
+	// 🔥 使用全局限速器
+	limiter := &limitedReader{ctx: m.ctx, r: resp.Body, limiter: DownloadLimiter}
 	buf := make([]byte, bufferSize)
 	
-	if _, err := io.CopyBuffer(pw, limiter, buf); err != nil {
+	if _, err := io.CopyBuffer(dst, limiter, buf); err != nil {
 		return err
 	}
 
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDownloadCancelResume ctx 被取消（Ctrl+C、SIGTERM）时已落盘的分块写入进度日志，
+// 下次运行只请求缺少的区间，拼出的文件与原文件一致
+func TestDownloadCancelResume(t *testing.T) {
+	data := make([]byte, 4*1024*1024)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	const partial = 256 * 1024
+
+	var mu sync.Mutex
+	stall := true
+	ranges := make([]string, 0)
+	started := make(chan struct{}, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		start, end := int64(0), int64(len(data))-1
+		if _, err := fmt.Sscanf(rng, "bytes=%d-%d", &start, &end); err != nil {
+			// 开始下载时的 bytes=0- 只用来取得文件大小
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", end, len(data)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+			return
+		}
+		mu.Lock()
+		ranges = append(ranges, rng)
+		stalled := stall
+		mu.Unlock()
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.Header().Set("Content-Length", fmt.Sprint(end-start+1))
+		w.WriteHeader(http.StatusPartialContent)
+		if !stalled || end-start+1 <= partial {
+			_, _ = w.Write(data[start : end+1])
+			return
+		}
+		// 第一次运行时大的分块只发送一部分，然后一直等到客户端断开
+		_, _ = w.Write(data[start : start+partial])
+		w.(http.Flusher).Flush()
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	m := NewDownloader(ctx, srv.URL+"/1.wav", dir, "1.wav", 2, nil)
+	result := make(chan error, 1)
+	go func() { result <- m.Download() }()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("blocks did not start")
+		}
+	}
+	// 给客户端一点时间读入已发送的部分
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled Download = %v, want context.Canceled", err)
+	}
+	if !m.Resumable() {
+		t.Fatal("no progress journal after cancel")
+	}
+
+	raw, err := os.ReadFile(m.journalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j progressJournal
+	if err := json.Unmarshal(raw, &j); err != nil {
+		t.Fatal(err)
+	}
+	if j.ContentLength != int64(len(data)) || len(j.Blocks) < 2 {
+		t.Fatalf("journal = %+v", j)
+	}
+	want := make([]string, 0, len(j.Blocks))
+	for _, b := range j.Blocks {
+		if b.Committed < b.Start || b.Committed > b.End+1 || (b.End-b.Start+1 > partial && b.Committed > b.Start+partial) {
+			t.Errorf("block %d-%d committed %d, outside the sent range", b.Start, b.End, b.Committed)
+		}
+		if b.Committed <= b.End {
+			want = append(want, fmt.Sprintf("bytes=%d-%d", b.Committed, b.End))
+		}
+	}
+
+	mu.Lock()
+	stall = false
+	ranges = ranges[:0]
+	mu.Unlock()
+
+	m = NewDownloader(context.Background(), srv.URL+"/1.wav", dir, "1.wav", 2, nil)
+	if err := m.Download(); err != nil {
+		t.Fatalf("resumed Download = %v", err)
+	}
+	got, err := os.ReadFile(m.FullPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("resumed file differs from the original")
+	}
+	if PathExists(m.journalPath()) {
+		t.Error("progress journal kept after a finished download")
+	}
+	mu.Lock()
+	sort.Strings(ranges)
+	sort.Strings(want)
+	if strings.Join(ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("resumed ranges = %v, want %v", ranges, want)
+	}
+	mu.Unlock()
+}
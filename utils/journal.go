@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"re-asmr-spider/i18n"
+)
+
+// 进度日志文件后缀，与临时文件放在同一目录
+const journalSuffix = ".progress"
+
+// 两次落盘之间的最短间隔
+const journalInterval = 2 * time.Second
+
+// ErrJournalMismatch 断点记录与服务器上的文件不一致，需要从头下载
+var ErrJournalMismatch = errors.New("progress journal does not match remote file")
+
+// progressJournal 断点续传记录
+// 只记录已经 Flush 到临时文件中的字节，进程崩溃后可以安全地从这里继续
+type progressJournal struct {
+	Url           string         `json:"url"`
+	ContentLength int64          `json:"content_length"`
+	Blocks        []journalBlock `json:"blocks"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+type journalBlock struct {
+	Start     int64 `json:"start"`
+	End       int64 `json:"end"`
+	Committed int64 `json:"committed"`
+}
+
+func (m *MultiThreadDownloader) journalPath() string {
+	return m.FullPath + journalSuffix
+}
+
+// RemovePartial 删除临时文件和它的进度日志
+func RemovePartial(fullPath string) {
+	_ = os.Remove(fullPath + journalSuffix)
+	_ = os.Remove(fullPath)
+}
+
+// Resumable 临时文件是否有可用于续传的进度记录
+func (m *MultiThreadDownloader) Resumable() bool {
+	return PathExists(m.journalPath()) && PathExists(m.FullPath)
+}
+
+// loadProgress 读取进度日志，成功时恢复 Blocks 与 ContentLength
+func (m *MultiThreadDownloader) loadProgress() bool {
+	if !m.Resumable() {
+		return false
+	}
+	data, err := os.ReadFile(m.journalPath())
+	if err != nil {
+		return false
+	}
+	var j progressJournal
+	if err := json.Unmarshal(data, &j); err != nil || j.ContentLength <= 0 || len(j.Blocks) == 0 {
+		m.discardProgress()
+		return false
+	}
+	if size, err := GetFileSize(m.FullPath); err != nil || size != j.ContentLength {
+		m.discardProgress()
+		return false
+	}
+	// 作品重新上传或临时路径对应的文件已变化，旧的区间不能拼进新文件
+	if !sameFile(j.Url, m.Url) || (m.ContentLength > 0 && m.ContentLength != j.ContentLength) {
+		Warning(i18n.T("progress_mismatch", m.FileName))
+		RemovePartial(m.FullPath)
+		return false
+	}
+	blocks := make([]*BlockMetaData, 0, len(j.Blocks))
+	for _, b := range j.Blocks {
+		// 从最后一次确认落盘的位置继续
+		blocks = append(blocks, &BlockMetaData{
+			StartOffset:    b.Start,
+			BeginOffset:    b.Committed,
+			EndOffset:      b.End,
+			DownloadedSize: b.Committed - b.Start,
+			committed:      b.Committed,
+		})
+	}
+	m.ContentLength = j.ContentLength
+	m.Blocks = blocks
+	return true
+}
+
+// sameFile 两个地址是否指向同一文件，只比较路径，切换镜像后仍可续传
+func sameFile(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.EscapedPath() == ub.EscapedPath()
+}
+
+// saveProgress 将各分块已落盘的位置写入进度日志
+func (m *MultiThreadDownloader) saveProgress() error {
+	m.journalMu.Lock()
+	defer m.journalMu.Unlock()
+
+	blocks := make([]journalBlock, 0, len(m.Blocks))
+	for _, b := range m.Blocks {
+		blocks = append(blocks, journalBlock{Start: b.StartOffset, End: b.EndOffset, Committed: b.committed})
+	}
+
+	data, err := json.Marshal(&progressJournal{
+		Url:           m.Url,
+		ContentLength: m.ContentLength,
+		Blocks:        blocks,
+		UpdatedAt:     time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免崩溃时留下半截 JSON
+	tmp := m.journalPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.journalPath())
+}
+
+// discardProgress 删除进度日志
+func (m *MultiThreadDownloader) discardProgress() {
+	_ = os.Remove(m.journalPath())
+}
+
+// commitBlock 记录分块已落盘的位置，并按间隔刷新进度日志（调用前必须先 Flush）
+func (m *MultiThreadDownloader) commitBlock(block *BlockMetaData, force bool) {
+	m.journalMu.Lock()
+	block.committed = block.BeginOffset
+	m.updateProgress()
+	due := force || time.Since(m.lastJournal) >= journalInterval
+	if due {
+		m.lastJournal = time.Now()
+	}
+	m.journalMu.Unlock()
+
+	if due {
+		if err := m.saveProgress(); err != nil {
+			Warning(i18n.T("save_progress_failed", err))
+		}
+	}
+}
+
+// updateProgress 按各分块已落盘的位置更新进度快照，调用方需持有 journalMu
+func (m *MultiThreadDownloader) updateProgress() {
+	p := &DownloadProgress{Total: m.ContentLength, Blocks: make([]BlockProgress, 0, len(m.Blocks))}
+	for _, b := range m.Blocks {
+		done := b.committed - b.StartOffset
+		p.Blocks = append(p.Blocks, BlockProgress{Start: b.StartOffset, End: b.EndOffset, Done: done})
+		p.Done += done
+	}
+	m.progress = p
+}
+
+// journalWriter 单线程下载时推进分块位置，并定期落盘记录进度
+type journalWriter struct {
+	m     *MultiThreadDownloader
+	buf   *bufio.Writer
+	block *BlockMetaData
+	next  io.Writer
+
+	lastFlush time.Time
+}
+
+func (jw *journalWriter) Write(p []byte) (int, error) {
+	n, err := jw.next.Write(p)
+	jw.block.BeginOffset += int64(n)
+	jw.block.DownloadedSize += int64(n)
+	if err == nil && time.Since(jw.lastFlush) >= journalInterval {
+		if err := jw.buf.Flush(); err != nil {
+			return n, err
+		}
+		jw.m.commitBlock(jw.block, false)
+		jw.lastFlush = time.Now()
+	}
+	return n, err
+}
+
+// contentRangeTotal 解析 Content-Range: bytes a-b/total 中的总长度
+func contentRangeTotal(header string) (int64, bool) {
+	idx := strings.LastIndex(header, "/")
+	if idx < 0 || idx == len(header)-1 {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(header[idx+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return total, true
+}
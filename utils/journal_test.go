@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadProgressMatchesFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		expected int64
+		want     bool
+	}{
+		{"same url", "https://a.example/media/1.wav?token=x", 0, true},
+		{"other mirror", "https://b.example/media/1.wav?token=y", 0, true},
+		{"expected size matches", "https://a.example/media/1.wav", 10, true},
+		{"other file", "https://a.example/media/2.wav", 0, false},
+		{"size changed", "https://a.example/media/1.wav", 12, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		old := &MultiThreadDownloader{
+			Url:           "https://a.example/media/1.wav?token=x",
+			FullPath:      filepath.Join(dir, "1.wav"),
+			ContentLength: 10,
+			Blocks:        []*BlockMetaData{{StartOffset: 0, EndOffset: 9, committed: 4}},
+		}
+		if err := os.WriteFile(old.FullPath, make([]byte, 10), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := old.saveProgress(); err != nil {
+			t.Fatal(err)
+		}
+
+		m := &MultiThreadDownloader{Url: tt.url, FullPath: old.FullPath, ContentLength: tt.expected}
+		if got := m.loadProgress(); got != tt.want {
+			t.Errorf("%s: loadProgress = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		if tt.want {
+			if m.ContentLength != 10 || len(m.Blocks) != 1 || m.Blocks[0].BeginOffset != 4 {
+				t.Errorf("%s: restored %d bytes, blocks %+v", tt.name, m.ContentLength, m.Blocks)
+			}
+		} else if PathExists(m.FullPath) || PathExists(m.journalPath()) {
+			t.Errorf("%s: partial file or journal was kept", tt.name)
+		}
+	}
+}
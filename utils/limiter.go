@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BandwidthLimiter 全局令牌桶限速器，所有下载流共享同一个速率
+// 支持 rclone --bwlimit 风格的时间表，例如 "08:00,5M 19:00,off" 或 "Mon-08:00,5M Sat-00:00,off"
+type BandwidthLimiter struct {
+	mu       sync.Mutex
+	schedule []bwSlot
+	tokens   float64
+	last     time.Time
+	lastRate int64
+	// capRate 动态上限（由自适应限速设置），0 表示不设上限
+	capRate int64
+	// total 经过限速器的总字节数，用于监控指标
+	total int64
+}
+
+// bwSlot 时间表中的一项，从 minute（一周内的分钟数）开始生效
+type bwSlot struct {
+	minute int
+	rate   int64 // 字节/秒，0 表示不限速
+}
+
+const minutesPerWeek = 7 * 24 * 60
+
+var weekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+var (
+	// DownloadLimiter 下载限速，所有 MultiThreadDownloader 共享
+	DownloadLimiter = &BandwidthLimiter{}
+	// UploadLimiter 写入挂载点/本地存储时的限速
+	UploadLimiter = &BandwidthLimiter{}
+)
+
+// NewBandwidthLimiter 解析限速设置，空字符串或 off 表示不限速
+func NewBandwidthLimiter(spec string) (*BandwidthLimiter, error) {
+	l := &BandwidthLimiter{}
+	if err := l.SetSchedule(spec); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetSchedule 更新限速设置
+func (l *BandwidthLimiter) SetSchedule(spec string) error {
+	schedule, err := parseBwSchedule(spec)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.schedule = schedule
+	return nil
+}
+
+func parseBwSchedule(spec string) ([]bwSlot, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	// 单个速率，不带时间表
+	if !strings.Contains(spec, ",") {
+		rate, err := parseBwRate(spec)
+		if err != nil {
+			return nil, err
+		}
+		return []bwSlot{{minute: 0, rate: rate}}, nil
+	}
+
+	schedule := make([]bwSlot, 0)
+	for _, entry := range strings.Fields(spec) {
+		parts := strings.SplitN(entry, ",", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid bwlimit entry: %s", entry)
+		}
+		rate, err := parseBwRate(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		day := -1
+		clock := parts[0]
+		if idx := strings.Index(clock, "-"); idx >= 0 {
+			d, ok := weekdays[strings.ToLower(clock[:idx])]
+			if !ok {
+				return nil, fmt.Errorf("invalid bwlimit weekday: %s", entry)
+			}
+			day = d
+			clock = clock[idx+1:]
+		}
+		hm := strings.SplitN(clock, ":", 2)
+		if len(hm) != 2 {
+			return nil, fmt.Errorf("invalid bwlimit time: %s", entry)
+		}
+		hour, err1 := strconv.Atoi(hm[0])
+		minute, err2 := strconv.Atoi(hm[1])
+		if err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+			return nil, fmt.Errorf("invalid bwlimit time: %s", entry)
+		}
+
+		offset := hour*60 + minute
+		if day >= 0 {
+			schedule = append(schedule, bwSlot{minute: day*24*60 + offset, rate: rate})
+		} else {
+			// 不指定星期时每天生效
+			for d := 0; d < 7; d++ {
+				schedule = append(schedule, bwSlot{minute: d*24*60 + offset, rate: rate})
+			}
+		}
+	}
+	sort.Slice(schedule, func(a, b int) bool {
+		return schedule[a].minute < schedule[b].minute
+	})
+	return schedule, nil
+}
+
+func parseBwRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "off") {
+		return 0, nil
+	}
+	return ParseSize(s)
+}
+
+// SetCap 设置动态上限，实际速率取时间表与上限中较小的一个，0 表示取消上限
+func (l *BandwidthLimiter) SetCap(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.capRate = rate
+}
+
+// rateAt 返回指定时刻生效的速率（调用方需持有锁）
+func (l *BandwidthLimiter) rateAt(now time.Time) int64 {
+	rate := l.scheduledRate(now)
+	if l.capRate > 0 && (rate == 0 || l.capRate < rate) {
+		return l.capRate
+	}
+	return rate
+}
+
+func (l *BandwidthLimiter) scheduledRate(now time.Time) int64 {
+	if len(l.schedule) == 0 {
+		return 0
+	}
+	current := int(now.Weekday())*24*60 + now.Hour()*60 + now.Minute()
+	// 早于本周第一项时，沿用上周最后一项
+	rate := l.schedule[len(l.schedule)-1].rate
+	for _, slot := range l.schedule {
+		if slot.minute > current {
+			break
+		}
+		rate = slot.rate
+	}
+	return rate
+}
+
+// Rate 当前生效的速率（字节/秒），0 表示不限速
+func (l *BandwidthLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rateAt(time.Now())
+}
+
+// Total 经过限速器的总字节数（无论是否限速）
+func (l *BandwidthLimiter) Total() int64 {
+	return atomic.LoadInt64(&l.total)
+}
+
+// WaitN 取得 n 字节的额度，额度不足时阻塞，ctx 结束时提前返回 ctx.Err()
+// 令牌允许透支，透支部分由调用方睡眠偿还，多个流并发时总速率仍为设定值
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	atomic.AddInt64(&l.total, int64(n))
+
+	l.mu.Lock()
+	now := time.Now()
+	rate := l.rateAt(now)
+	if rate <= 0 {
+		l.lastRate = 0
+		l.mu.Unlock()
+		return nil
+	}
+
+	// 速率变化（时间表切换）时重置令牌桶
+	if rate != l.lastRate || l.last.IsZero() {
+		l.tokens = 0
+		l.lastRate = rate
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * float64(rate)
+		// 最多积攒 1 秒的突发额度
+		if l.tokens > float64(rate) {
+			l.tokens = float64(rate)
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		return SleepContext(ctx, wait)
+	}
+	return nil
+}
+
+// limitedReader 每次读取后向限速器申请额度
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *BandwidthLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if waitErr := lr.limiter.WaitN(lr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
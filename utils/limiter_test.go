@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// at 返回 2024-01-07（周日）之后第 day 天的 hh:mm
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, 1, 7+day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestScheduledRate(t *testing.T) {
+	const (
+		sun, mon, tue, wed, sat = 0, 1, 2, 3, 6
+	)
+	tests := []struct {
+		name string
+		spec string
+		now  time.Time
+		want int64
+	}{
+		{"empty", "", at(wed, 12, 0), 0},
+		{"off", "off", at(wed, 12, 0), 0},
+		{"single rate", "10M", at(wed, 12, 0), 10 << 20},
+		{"lowercase unit", "512k", at(wed, 12, 0), 512 << 10},
+		{"fractional unit", "1.5M", at(wed, 12, 0), 3 << 19},
+		{"daily slot", "08:00,5M 19:00,off", at(wed, 12, 0), 5 << 20},
+		{"daily off", "08:00,5M 19:00,off", at(wed, 20, 0), 0},
+		{"slot boundary", "08:00,5M 19:00,off", at(wed, 8, 0), 5 << 20},
+		{"before first slot of the day", "08:00,5M 19:00,off", at(wed, 7, 59), 0},
+		{"midnight wrap", "22:00,1M 06:00,off", at(wed, 2, 0), 1 << 20},
+		{"midnight wrap into week start", "22:00,1M 06:00,off", at(sun, 1, 0), 1 << 20},
+		{"mixed units", "00:00,512K 12:00,1.5M 18:00,2G", at(tue, 13, 0), 3 << 19},
+		{"mixed units last slot", "00:00,512K 12:00,1.5M 18:00,2G", at(tue, 23, 59), 2 << 30},
+		{"weekday slot", "Mon-08:00,512K Sat-00:00,off", at(tue, 12, 0), 512 << 10},
+		{"weekday wraps to saturday", "Mon-08:00,512K Sat-00:00,off", at(sun, 12, 0), 0},
+		{"weekday before monday slot", "Mon-08:00,512K Sat-00:00,off", at(mon, 7, 0), 0},
+		{"weekday saturday", "Mon-08:00,512K Sat-00:00,off", at(sat, 0, 0), 0},
+		{"weekday mixed case", "mON-08:00,1M", at(wed, 0, 0), 1 << 20},
+	}
+	for _, tt := range tests {
+		l, err := NewBandwidthLimiter(tt.spec)
+		if err != nil {
+			t.Errorf("%s: NewBandwidthLimiter(%q): %v", tt.name, tt.spec, err)
+			continue
+		}
+		if got := l.scheduledRate(tt.now); got != tt.want {
+			t.Errorf("%s: scheduledRate(%q, %s) = %d, want %d", tt.name, tt.spec, tt.now.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseBwScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"fast",
+		"-1M",
+		"08:00,5M 24:00,off",
+		"08:60,5M",
+		"0800,5M",
+		"Xyz-08:00,5M",
+		"08:00,5M 19:00,slow",
+	} {
+		if _, err := parseBwSchedule(spec); err == nil {
+			t.Errorf("parseBwSchedule(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestRateAtCap(t *testing.T) {
+	l, err := NewBandwidthLimiter("08:00,5M 19:00,off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetCap(1 << 20)
+	if got := l.rateAt(at(3, 12, 0)); got != 1<<20 {
+		t.Errorf("cap below schedule: got %d, want %d", got, 1<<20)
+	}
+	if got := l.rateAt(at(3, 20, 0)); got != 1<<20 {
+		t.Errorf("cap while schedule is off: got %d, want %d", got, 1<<20)
+	}
+	l.SetCap(10 << 20)
+	if got := l.rateAt(at(3, 12, 0)); got != 5<<20 {
+		t.Errorf("cap above schedule: got %d, want %d", got, 5<<20)
+	}
+}
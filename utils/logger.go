@@ -2,6 +2,8 @@ package utils
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"runtime"
 	"time"
 )
@@ -34,11 +36,13 @@ const (
 type Logger struct {
 	enableColor bool
 	showTime    bool
+	out         io.Writer
 }
 
 var defaultLogger = &Logger{
 	enableColor: runtime.GOOS != "windows" || isWindowsColorSupported(),
 	showTime:    false,
+	out:         os.Stdout,
 }
 
 // isWindowsColorSupported 检查Windows是否支持ANSI颜色（Windows 10+）
@@ -74,16 +78,16 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 	if l.enableColor {
 		if l.showTime {
 			timestamp := time.Now().Format("15:04:05")
-			fmt.Printf("%s%s%s %s%s%s %s\n", colorGray, timestamp, colorReset, color, prefix, colorReset, message)
+			fmt.Fprintf(l.out, "%s%s%s %s%s%s %s\n", colorGray, timestamp, colorReset, color, prefix, colorReset, message)
 		} else {
-			fmt.Printf("%s%s%s %s\n", color, prefix, colorReset, message)
+			fmt.Fprintf(l.out, "%s%s%s %s\n", color, prefix, colorReset, message)
 		}
 	} else {
 		if l.showTime {
 			timestamp := time.Now().Format("15:04:05")
-			fmt.Printf("%s %s %s\n", timestamp, prefix, message)
+			fmt.Fprintf(l.out, "%s %s %s\n", timestamp, prefix, message)
 		} else {
-			fmt.Printf("%s %s\n", prefix, message)
+			fmt.Fprintf(l.out, "%s %s\n", prefix, message)
 		}
 	}
 }
@@ -122,3 +126,8 @@ func SetColorEnabled(enabled bool) {
 func SetShowTime(show bool) {
 	defaultLogger.showTime = show
 }
+
+// SetOutput 设置日志输出位置（例如输出 JSON 时改为 stderr）
+func SetOutput(w io.Writer) {
+	defaultLogger.out = w
+}
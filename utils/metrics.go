@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 文件计数，成功/失败由 WorkerPool 统计，重试由调用方通过 CountRetry 统计
+var (
+	filesCompleted int64
+	filesFailed    int64
+	filesRetried   int64
+)
+
+// CountRetry 记录一次失败文件的重新下载
+func CountRetry() {
+	atomic.AddInt64(&filesRetried, 1)
+}
+
+// latencyBuckets 请求耗时直方图的上界（秒），耗时只计到收到响应头
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// hostStats 单个主机的请求统计
+type hostStats struct {
+	codes   map[string]int64
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+var (
+	httpStatsMu sync.Mutex
+	httpStats   = make(map[string]*hostStats)
+)
+
+func observeRequest(host, code string, elapsed time.Duration) {
+	httpStatsMu.Lock()
+	defer httpStatsMu.Unlock()
+	s, ok := httpStats[host]
+	if !ok {
+		s = &hostStats{codes: make(map[string]int64), buckets: make([]int64, len(latencyBuckets))}
+		httpStats[host] = s
+	}
+	s.codes[code]++
+	seconds := elapsed.Seconds()
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+// instrumentedTransport 按主机统计请求耗时和状态码，连接失败记为 code="error"
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	observeRequest(req.URL.Host, code, time.Since(start))
+	return resp, err
+}
+
+// MetricWriter 按 Prometheus 文本格式输出指标
+type MetricWriter struct {
+	W io.Writer
+}
+
+// Header 输出指标的 HELP 和 TYPE 行，kind 为 counter/gauge/histogram
+func (mw MetricWriter) Header(name, kind, help string) {
+	fmt.Fprintf(mw.W, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Sample 输出一个样本，labels 为成对的标签名和值
+func (mw MetricWriter) Sample(name string, value float64, labels ...string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(mw.W, "%s %s\n", name, formatMetricValue(value))
+		return
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	fmt.Fprintf(mw.W, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatMetricValue(value))
+}
+
+// Metric 输出只有一个样本的指标
+func (mw MetricWriter) Metric(name, kind, help string, value float64) {
+	mw.Header(name, kind, help)
+	mw.Sample(name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// WriteMetrics 输出下载、队列、rclone 缓存和 HTTP 请求的指标，ctx 结束时不再等待 rclone
+func WriteMetrics(ctx context.Context, w io.Writer, pool *WorkerPool) {
+	mw := MetricWriter{W: w}
+
+	mw.Metric("asmr_downloaded_bytes_total", "counter", "Bytes read from download responses.", float64(DownloadLimiter.Total()))
+	mw.Metric("asmr_uploaded_bytes_total", "counter", "Bytes copied to the mount or local storage.", float64(UploadLimiter.Total()))
+	mw.Metric("asmr_files_completed_total", "counter", "Files downloaded and stored successfully.", float64(atomic.LoadInt64(&filesCompleted)))
+	mw.Metric("asmr_files_failed_total", "counter", "Failed download attempts, excluding paused and canceled files.", float64(atomic.LoadInt64(&filesFailed)))
+	mw.Metric("asmr_files_retried_total", "counter", "Failed files submitted again for download.", float64(atomic.LoadInt64(&filesRetried)))
+
+	queued := 0
+	for _, a := range pool.Active() {
+		if !a.Running {
+			queued++
+		}
+	}
+	mw.Metric("asmr_workers_active", "gauge", "Files currently downloading or uploading.", float64(pool.Running()))
+	mw.Metric("asmr_workers_limit", "gauge", "Maximum number of concurrent files.", float64(pool.GetLimit()))
+	mw.Metric("asmr_queue_depth", "gauge", "Files submitted but not started yet.", float64(queued))
+	mw.Metric("asmr_inactive_seconds", "gauge", "Seconds since the last download activity.", GlobalMonitor.GetInactiveTime().Seconds())
+
+	writeCacheMetrics(ctx, mw)
+	writeHTTPMetrics(mw)
+}
+
+// metricsRcloneTimeout 抓取指标时查询 rclone 缓存的最长时间
+const metricsRcloneTimeout = 3 * time.Second
+
+func writeCacheMetrics(ctx context.Context, mw MetricWriter) {
+	mw.Metric("asmr_rclone_flow_control_enabled", "gauge", "Whether rclone VFS cache flow control is enabled.", boolMetric(FlowControl.IsEnabled()))
+	mw.Metric("asmr_rclone_cache_paused", "gauge", "Whether writes to the mount are paused waiting for the VFS cache to drain.", boolMetric(atomic.LoadInt32(&cacheWaiting) > 0))
+	if !FlowControl.IsEnabled() {
+		return
+	}
+	// 抓取不能被 rclone 拖住，查询超过 metricsRcloneTimeout 时记为 rclone 不可用
+	ctx, cancel := context.WithTimeout(ctx, metricsRcloneTimeout)
+	defer cancel()
+	status, err := GetCacheStatus(ctx)
+	mw.Metric("asmr_rclone_up", "gauge", "Whether the rclone rc API answered the last query.", boolMetric(err == nil))
+	if err != nil || !status.Enabled {
+		return
+	}
+	mw.Metric("asmr_rclone_cache_bytes", "gauge", "rclone VFS diskCache.bytesUsed.", float64(status.BytesUsed))
+	mw.Metric("asmr_rclone_cache_pause_threshold_bytes", "gauge", "Cache usage above which writes are paused.", float64(status.PauseThreshold))
+	mw.Metric("asmr_rclone_cache_resume_threshold_bytes", "gauge", "Cache usage below which writes resume.", float64(status.ResumeThreshold))
+	mw.Metric("asmr_rclone_uploads_in_progress", "gauge", "Files rclone is uploading from the VFS cache.", float64(status.UploadsInProgress))
+	mw.Metric("asmr_rclone_uploads_queued", "gauge", "Files waiting in the rclone VFS upload queue.", float64(status.UploadsQueued))
+}
+
+func writeHTTPMetrics(mw MetricWriter) {
+	httpStatsMu.Lock()
+	defer httpStatsMu.Unlock()
+	hosts := make([]string, 0, len(httpStats))
+	for host := range httpStats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	mw.Header("asmr_http_requests_total", "counter", "HTTP requests by host and status code.")
+	for _, host := range hosts {
+		codes := make([]string, 0, len(httpStats[host].codes))
+		for code := range httpStats[host].codes {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			mw.Sample("asmr_http_requests_total", float64(httpStats[host].codes[code]), "host", host, "code", code)
+		}
+	}
+
+	name := "asmr_http_request_duration_seconds"
+	mw.Header(name, "histogram", "Time until response headers by host.")
+	for _, host := range hosts {
+		s := httpStats[host]
+		for i, le := range latencyBuckets {
+			mw.Sample(name+"_bucket", float64(s.buckets[i]), "host", host, "le", formatMetricValue(le))
+		}
+		mw.Sample(name+"_bucket", float64(s.count), "host", host, "le", "+Inf")
+		mw.Sample(name+"_sum", s.sum, "host", host)
+		mw.Sample(name+"_count", float64(s.count), "host", host)
+	}
+}
@@ -46,7 +46,8 @@ var Client = sync.Pool{
 	New: func() interface{} {
 		return &http.Client{
 			Timeout: 180 * time.Second, // 3分钟总超时
-			Transport: &http.Transport{
+			// 按主机统计请求耗时和状态码，见 metrics.go
+			Transport: &instrumentedTransport{next: &http.Transport{
 				Proxy: getProxyFunc(),
 				TLSClientConfig: &tls.Config{
 					MaxVersion: tls.VersionTLS12, // Cloudflare 会杀
@@ -58,7 +59,7 @@ var Client = sync.Pool{
 				ResponseHeaderTimeout: 30 * time.Second,  // 响应头超时
 				ExpectContinueTimeout: 1 * time.Second,   // Expect: 100-continue超时
 				DisableKeepAlives:     false,             // 启用Keep-Alive
-			},
+			}},
 		}
 	},
 }
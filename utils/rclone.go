@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"re-asmr-spider/i18n"
+)
+
+// RcloneClient rclone 远程控制 (RC) 接口客户端
+// 需要 rclone 启动时带上 --rc 参数，默认监听 127.0.0.1:5572
+type RcloneClient struct {
+	URL string
+	// User/Pass 对应 --rc-user/--rc-pass，为空时不发送认证头
+	User string
+	Pass string
+}
+
+// Rclone 全局 RC 客户端
+var Rclone = &RcloneClient{URL: "http://127.0.0.1:5572"}
+
+// RcloneFlowControl 写入挂载点前的 VFS 缓存流控设置
+type RcloneFlowControl struct {
+	// 缓存超过 PauseThreshold 时暂停写入，降到 ResumeThreshold 以下恢复
+	// 两者为 0 时根据 vfs/stats 中的 CacheMaxSize 自动计算
+	PauseThreshold  int64
+	ResumeThreshold int64
+
+	mu sync.Mutex
+	// enabled 为 false 时直接写入，适用于没有运行 rclone 的机器
+	// 单独加锁，读取时不必等待 Thresholds 中的 vfs/stats 请求
+	enabled   bool
+	enabledMu sync.RWMutex
+}
+
+// FlowControl 全局流控设置，默认值适配 --vfs-cache-max-size 20G
+var FlowControl = &RcloneFlowControl{
+	enabled:         true,
+	PauseThreshold:  18 * 1024 * 1024 * 1024,
+	ResumeThreshold: 15 * 1024 * 1024 * 1024,
+}
+
+// 自动计算阈值时占 CacheMaxSize 的比例
+const (
+	autoPauseRatio  = 0.90
+	autoResumeRatio = 0.75
+)
+
+// rc 接口在本机，不走代理
+var rcHTTPClient = &http.Client{
+	Transport: &http.Transport{Proxy: nil},
+}
+
+// Call 调用 RC 接口，普通查询 30 秒超时，ctx 结束时中断请求
+func (rc *RcloneClient) Call(ctx context.Context, method string, in interface{}, out interface{}) error {
+	return rc.call(ctx, method, in, out, 30*time.Second)
+}
+
+// call timeout 为 0 时不限时（用于上传大文件），ctx 结束时中断请求
+func (rc *RcloneClient) call(ctx context.Context, method string, in interface{}, out interface{}, timeout time.Duration) error {
+	if in == nil {
+		in = map[string]interface{}{}
+	}
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimRight(rc.URL, "/")+"/"+method, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if rc.User != "" {
+		req.SetBasicAuth(rc.User, rc.Pass)
+	}
+
+	client := *rcHTTPClient
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// rclone 出错时返回 {"error": "..."}
+		var rcErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &rcErr) == nil && rcErr.Error != "" {
+			return fmt.Errorf("rclone %s: %s", method, rcErr.Error)
+		}
+		return fmt.Errorf("rclone %s: status %d", method, resp.StatusCode)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// 定义 Rclone 返回的 JSON 结构 (已修复，嵌套到 diskCache.bytesUsed)
+type RcloneVFSStats struct {
+	DiskCache struct {
+		BytesUsed int64 `json:"bytesUsed"`
+		// 正在上传和排队等待上传的文件数
+		UploadsInProgress int `json:"uploadsInProgress"`
+		UploadsQueued     int `json:"uploadsQueued"`
+	} `json:"diskCache"`
+	Opt struct {
+		// 不同版本的 rclone 可能输出数字或 "20Gi" 这样的字符串
+		CacheMaxSize json.RawMessage `json:"CacheMaxSize"`
+	} `json:"opt"`
+}
+
+// CacheMaxSize 解析 --vfs-cache-max-size，未设置或不限制时返回 0
+func (s *RcloneVFSStats) CacheMaxSize() int64 {
+	raw := strings.TrimSpace(string(s.Opt.CacheMaxSize))
+	if raw == "" || raw == "null" {
+		return 0
+	}
+	var n int64
+	if err := json.Unmarshal(s.Opt.CacheMaxSize, &n); err == nil {
+		if n < 0 {
+			return 0
+		}
+		return n
+	}
+	var str string
+	if err := json.Unmarshal(s.Opt.CacheMaxSize, &str); err == nil && str != "off" {
+		if size, err := ParseSize(str); err == nil {
+			return size
+		}
+	}
+	return 0
+}
+
+func getRcloneVFSStats(ctx context.Context) (*RcloneVFSStats, error) {
+	var stats RcloneVFSStats
+	if err := Rclone.Call(ctx, "vfs/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// RcloneCoreStats core/stats 中用到的字段
+type RcloneCoreStats struct {
+	// Speed 当前总传输速度（字节/秒）
+	Speed float64 `json:"speed"`
+}
+
+func getRcloneCoreStats(ctx context.Context) (*RcloneCoreStats, error) {
+	var stats RcloneCoreStats
+	if err := Rclone.Call(ctx, "core/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// 🔥 新增：通过 API 获取 Rclone 当前缓存占用
+func getRcloneCacheUsage(ctx context.Context) (int64, error) {
+	stats, err := getRcloneVFSStats(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// 返回嵌套结构中的 BytesUsed
+	return stats.DiskCache.BytesUsed, nil
+}
+
+// CacheStatus VFS 缓存占用与流控阈值
+type CacheStatus struct {
+	Enabled           bool  `json:"enabled"`
+	BytesUsed         int64 `json:"bytes_used"`
+	PauseThreshold    int64 `json:"pause_threshold"`
+	ResumeThreshold   int64 `json:"resume_threshold"`
+	UploadsInProgress int   `json:"uploads_in_progress"`
+	UploadsQueued     int   `json:"uploads_queued"`
+}
+
+// GetCacheStatus 查询当前缓存占用，流控关闭时只返回 Enabled=false
+func GetCacheStatus(ctx context.Context) (*CacheStatus, error) {
+	status := &CacheStatus{Enabled: FlowControl.IsEnabled()}
+	if !status.Enabled {
+		return status, nil
+	}
+	pause, resume, err := FlowControl.Thresholds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := getRcloneVFSStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.PauseThreshold, status.ResumeThreshold = pause, resume
+	status.BytesUsed = stats.DiskCache.BytesUsed
+	status.UploadsInProgress = stats.DiskCache.UploadsInProgress
+	status.UploadsQueued = stats.DiskCache.UploadsQueued
+	return status, nil
+}
+
+// IsEnabled 是否在写入前检查缓存占用
+func (fc *RcloneFlowControl) IsEnabled() bool {
+	fc.enabledMu.RLock()
+	defer fc.enabledMu.RUnlock()
+	return fc.enabled
+}
+
+// SetEnabled 开启或关闭缓存流控
+func (fc *RcloneFlowControl) SetEnabled(enabled bool) {
+	fc.enabledMu.Lock()
+	defer fc.enabledMu.Unlock()
+	fc.enabled = enabled
+}
+
+// Thresholds 返回当前生效的暂停/恢复阈值，需要时根据 rclone 的缓存上限推导
+// rclone 未限制缓存大小时关闭流控并返回 0
+func (fc *RcloneFlowControl) Thresholds(ctx context.Context) (pause int64, resume int64, err error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.PauseThreshold > 0 && fc.ResumeThreshold > 0 {
+		return fc.PauseThreshold, fc.ResumeThreshold, nil
+	}
+
+	stats, err := getRcloneVFSStats(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	maxSize := stats.CacheMaxSize()
+	if maxSize <= 0 {
+		// 缓存不限大小，无需流控
+		Warning(i18n.T("rclone_cache_unlimited"))
+		fc.SetEnabled(false)
+		return 0, 0, nil
+	}
+	if fc.PauseThreshold <= 0 {
+		fc.PauseThreshold = int64(float64(maxSize) * autoPauseRatio)
+	}
+	if fc.ResumeThreshold <= 0 {
+		fc.ResumeThreshold = int64(float64(maxSize) * autoResumeRatio)
+	}
+	Info(i18n.T("rclone_auto_threshold", FormatSize(maxSize), FormatSize(fc.PauseThreshold), FormatSize(fc.ResumeThreshold)))
+	return fc.PauseThreshold, fc.ResumeThreshold, nil
+}
@@ -0,0 +1,311 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"re-asmr-spider/i18n"
+)
+
+// 存储后端类型
+const (
+	StorageLocal    = "local"     // 普通本地磁盘
+	StorageMount    = "mount"     // rclone FUSE 挂载点，移动前检查 VFS 缓存
+	StorageRcloneRC = "rclone_rc" // 通过 rclone RC 的 operations/copyfile 直接上传，绕过 FUSE
+)
+
+// FileInfo 存储后端上的文件信息
+type FileInfo struct {
+	Size  int64
+	IsDir bool
+}
+
+// Storage 下载完成后文件的最终去处
+// 所有路径都是相对于存储根目录、以 / 分隔的路径，例如 RJ123456/SE/01.wav
+type Storage interface {
+	// Stat 获取文件信息，文件不存在时返回 os.ErrNotExist
+	Stat(ctx context.Context, name string) (FileInfo, error)
+	// Exists 文件是否存在
+	Exists(ctx context.Context, name string) bool
+	// Mkdir 创建目录（含父目录）
+	Mkdir(ctx context.Context, name string) error
+	// Put 将本地文件写入存储，不删除本地文件
+	// ctx 只用于中断写入前的等待和 RC 请求，已开始的复制会写完，避免挂载点上留下半个文件
+	Put(ctx context.Context, localPath, name string) error
+	// Location 用于日志显示的完整位置
+	Location(name string) string
+}
+
+// NewStorage 按类型创建存储后端
+// local/mount 的 root 是本地目录，rclone_rc 的 root 是 rclone 远程路径，例如 sp:DL
+func NewStorage(kind, root string) (Storage, error) {
+	switch kind {
+	case StorageLocal:
+		return &LocalStorage{Root: root}, nil
+	case StorageMount, "":
+		return &MountStorage{LocalStorage{Root: root}}, nil
+	case StorageRcloneRC:
+		if root == "" {
+			return nil, errors.New("rclone_rc storage requires a remote, e.g. sp:DL")
+		}
+		return &RcloneStorage{Remote: root, RC: Rclone}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", kind)
+	}
+}
+
+// LocalStorage 普通本地磁盘
+type LocalStorage struct {
+	Root string
+}
+
+func (s *LocalStorage) path(name string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(name))
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Size: info.Size(), IsDir: info.IsDir()}, nil
+}
+
+func (s *LocalStorage) Exists(ctx context.Context, name string) bool {
+	return PathExists(s.path(name))
+}
+
+func (s *LocalStorage) Mkdir(ctx context.Context, name string) error {
+	return os.MkdirAll(s.path(name), os.ModePerm)
+}
+
+// Put 同一分区时直接重命名，否则复制
+func (s *LocalStorage) Put(ctx context.Context, localPath, name string) error {
+	dst := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(localPath, dst); err == nil {
+		return nil
+	}
+	return copyFile(localPath, dst)
+}
+
+func (s *LocalStorage) Location(name string) string {
+	return s.path(name)
+}
+
+func (s *LocalStorage) Hash(name, hashType string) (string, error) {
+	return HashFile(s.path(name), hashType)
+}
+
+// MountStorage rclone FUSE 挂载点
+// 写入前等待 VFS 缓存降到阈值以下，防止爆缓存
+type MountStorage struct {
+	LocalStorage
+}
+
+func (s *MountStorage) Put(ctx context.Context, localPath, name string) error {
+	if err := waitForRcloneCache(ctx); err != nil {
+		return err
+	}
+
+	dst := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// 挂载点通常不在同一分区，直接复制
+	return copyFile(localPath, dst)
+}
+
+// RcloneStorage 通过 rclone RC 接口直接上传到远程，不经过挂载点的 VFS 缓存
+type RcloneStorage struct {
+	Remote string
+	RC     *RcloneClient
+}
+
+// rcloneItem operations/stat 返回的文件信息
+type rcloneItem struct {
+	Size  int64 `json:"Size"`
+	IsDir bool  `json:"IsDir"`
+}
+
+func (s *RcloneStorage) Stat(ctx context.Context, name string) (FileInfo, error) {
+	var res struct {
+		Item *rcloneItem `json:"item"`
+	}
+	err := s.RC.call(ctx, "operations/stat", map[string]interface{}{
+		"fs":     s.Remote,
+		"remote": name,
+	}, &res, 30*time.Second)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if res.Item == nil {
+		return FileInfo{}, os.ErrNotExist
+	}
+	return FileInfo{Size: res.Item.Size, IsDir: res.Item.IsDir}, nil
+}
+
+func (s *RcloneStorage) Exists(ctx context.Context, name string) bool {
+	_, err := s.Stat(ctx, name)
+	return err == nil
+}
+
+func (s *RcloneStorage) Mkdir(ctx context.Context, name string) error {
+	return s.RC.call(ctx, "operations/mkdir", map[string]interface{}{
+		"fs":     s.Remote,
+		"remote": name,
+	}, nil, 30*time.Second)
+}
+
+func (s *RcloneStorage) Put(ctx context.Context, localPath, name string) error {
+	abs, err := filepath.Abs(localPath)
+	if err != nil {
+		return err
+	}
+	return s.RC.call(ctx, "operations/copyfile", map[string]interface{}{
+		"srcFs":     filepath.Dir(abs),
+		"srcRemote": filepath.Base(abs),
+		"dstFs":     s.Remote,
+		"dstRemote": name,
+	}, nil, 0)
+}
+
+// Hash 通过 operations/hashsum 读取远程文件的校验和，网盘不支持该算法时返回 ErrHashUnsupported
+func (s *RcloneStorage) Hash(ctx context.Context, name, hashType string) (string, error) {
+	var res struct {
+		Hashsum []string `json:"hashsum"`
+	}
+	err := s.RC.call(ctx, "operations/hashsum", map[string]interface{}{
+		"fs":       s.Location(name),
+		"hashType": hashType,
+	}, &res, 30*time.Second)
+	if err != nil {
+		if strings.Contains(err.Error(), "not supported") || strings.Contains(err.Error(), "unsupported") {
+			return "", fmt.Errorf("%w: %v", ErrHashUnsupported, err)
+		}
+		return "", err
+	}
+	// 每行格式为 "<hash>  <文件名>"
+	for _, line := range res.Hashsum {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] != "" {
+			return fields[0], nil
+		}
+	}
+	return "", ErrHashUnsupported
+}
+
+func (s *RcloneStorage) Location(name string) string {
+	if strings.HasSuffix(s.Remote, ":") {
+		return s.Remote + name
+	}
+	return s.Remote + "/" + path.Clean(name)
+}
+
+// copyFile 复制文件，目标已存在时覆盖，受上传限速控制
+// 已开始的复制不因 ctx 中断，避免挂载点上留下半个文件
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("无法打开源文件: %v", err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("无法创建目标文件: %v", err)
+	}
+	if _, err := io.Copy(dstFile, &limitedReader{ctx: context.Background(), r: srcFile, limiter: UploadLimiter}); err != nil {
+		dstFile.Close()
+		return fmt.Errorf("写入挂载点失败: %v", err)
+	}
+	if err := dstFile.Close(); err != nil {
+		return fmt.Errorf("写入挂载点失败: %v", err)
+	}
+	return nil
+}
+
+// cacheWaiting 因缓存已满正在等待写入的文件数
+var cacheWaiting int32
+
+// rcloneMaxFailures 连续多少次连不上 rclone API 后放弃本次写入
+const rcloneMaxFailures = 3
+
+// ErrRcloneUnreachable 多次连接 rclone API 失败，文件留在临时目录，之后重试时再写入
+var ErrRcloneUnreachable = errors.New("rclone API unreachable")
+
+// 🔥🔥 Rclone 缓存监控流控 🔥🔥
+// 等待期间 ctx 结束时返回 ctx.Err()，连续 rcloneMaxFailures 次连不上 API 时返回 ErrRcloneUnreachable
+func waitForRcloneCache(ctx context.Context) error {
+	if !FlowControl.IsEnabled() {
+		return nil
+	}
+	for failures := 1; ; failures++ {
+		pause, resume, err := FlowControl.Thresholds(ctx)
+		if err == nil && pause == 0 {
+			return nil
+		}
+		if err == nil {
+			var usage int64
+			usage, err = getRcloneCacheUsage(ctx)
+			if err == nil {
+				// 如果当前缓存超过暂停阈值
+				if usage > pause {
+					Warning(i18n.T("rclone_cache_full", FormatSize(usage)))
+					// 进入等待模式，直到缓存降到恢复阈值以下
+					return waitForCacheDrain(ctx, resume)
+				}
+				// 缓存未满，直接通过
+				return nil
+			}
+		}
+
+		// 连接失败，打印错误并暂停，避免误判
+		// 不刷新活动时间，长时间连不上时由看门狗发现
+		Error(i18n.T("rclone_api_unreachable", err))
+		if failures >= rcloneMaxFailures {
+			return fmt.Errorf("%w: %v", ErrRcloneUnreachable, err)
+		}
+		if err := SleepContext(ctx, 10*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// waitForCacheDrain 每 10 秒检查一次，直到缓存降到 resume 以下
+func waitForCacheDrain(ctx context.Context, resume int64) error {
+	atomic.AddInt32(&cacheWaiting, 1)
+	defer atomic.AddInt32(&cacheWaiting, -1)
+	failures := 0
+	for {
+		if err := SleepContext(ctx, 10*time.Second); err != nil {
+			return err
+		}
+
+		newUsage, err := getRcloneCacheUsage(ctx)
+		if err != nil {
+			Error(i18n.T("rclone_api_unreachable", err))
+			if failures++; failures >= rcloneMaxFailures {
+				return fmt.Errorf("%w: %v", ErrRcloneUnreachable, err)
+			}
+			continue
+		}
+		failures = 0
+		// 缓存在正常下降，等待不算卡住
+		GlobalMonitor.UpdateActivity()
+		if newUsage < resume {
+			Success(i18n.T("rclone_cache_resumed", FormatSize(newUsage)))
+			return nil
+		}
+	}
+}
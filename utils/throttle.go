@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"re-asmr-spider/i18n"
+)
+
+// AdaptiveThrottle 根据 rclone VFS 上传积压动态调整下载速率
+// 缓存占用在恢复阈值和暂停阈值之间时，按占用比例把下载速率压到上传速度的 2 倍到 0.5 倍，
+// 让缓存平稳增长，而不是涨满后所有任务同时卡在 waitForRcloneCache
+type AdaptiveThrottle struct {
+	Enabled bool
+	// Interval 轮询 rclone 的间隔
+	Interval time.Duration
+	// MinRate 限速的下限，避免上传速度统计为 0 时下载完全停住
+	MinRate int64
+
+	mu         sync.Mutex
+	stop       context.CancelFunc // 结束轮询并中断进行中的 rclone 请求
+	speed      float64            // 平滑后的上传速度
+	throttling bool
+}
+
+// Throttle 全局自适应限速，作用于 DownloadLimiter
+var Throttle = &AdaptiveThrottle{
+	Enabled:  true,
+	Interval: 10 * time.Second,
+	MinRate:  512 * 1024,
+}
+
+// Start 开始轮询，重复调用无副作用
+func (t *AdaptiveThrottle) Start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.Enabled || t.stop != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.stop = cancel
+	go t.loop(ctx)
+}
+
+// Stop 停止轮询并取消动态上限
+func (t *AdaptiveThrottle) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stop == nil {
+		return
+	}
+	t.stop()
+	t.stop = nil
+	t.speed = 0
+	t.throttling = false
+	DownloadLimiter.SetCap(0)
+}
+
+func (t *AdaptiveThrottle) loop(ctx context.Context) {
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+
+	t.adjust(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			t.adjust(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// adjust 读取一次 rclone 状态并更新下载上限
+func (t *AdaptiveThrottle) adjust(ctx context.Context) {
+	rate := t.target(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ctx.Err() != nil {
+		// 已停止，Stop 在持有锁时取消 ctx
+		return
+	}
+	DownloadLimiter.SetCap(rate)
+	if rate > 0 && !t.throttling {
+		Warning(i18n.T("rclone_throttle_on", FormatSize(rate)))
+	} else if rate == 0 && t.throttling {
+		Info(i18n.T("rclone_throttle_off"))
+	}
+	t.throttling = rate > 0
+}
+
+// target 计算下载上限，0 表示不限制
+// rclone 不可用时返回 0，由写入前的暂停/恢复兜底
+func (t *AdaptiveThrottle) target(ctx context.Context) int64 {
+	if !FlowControl.IsEnabled() {
+		return 0
+	}
+	pause, resume, err := FlowControl.Thresholds(ctx)
+	if err != nil || pause == 0 || pause <= resume {
+		return 0
+	}
+	vfs, err := getRcloneVFSStats(ctx)
+	if err != nil {
+		return 0
+	}
+	core, err := getRcloneCoreStats(ctx)
+	if err != nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	if t.speed == 0 {
+		t.speed = core.Speed
+	} else {
+		t.speed = t.speed*0.5 + core.Speed*0.5
+	}
+	speed := t.speed
+	t.mu.Unlock()
+
+	// 没有待上传的文件时，缓存里只是读缓存，rclone 会自行清理
+	backlog := vfs.DiskCache.UploadsInProgress + vfs.DiskCache.UploadsQueued
+	usage := vfs.DiskCache.BytesUsed
+	if backlog == 0 || usage <= resume {
+		return 0
+	}
+
+	pressure := float64(usage-resume) / float64(pause-resume)
+	if pressure > 1 {
+		pressure = 1
+	}
+	rate := int64(speed * (2 - 1.5*pressure))
+	if rate < t.MinRate {
+		rate = t.MinRate
+	}
+	return rate
+}
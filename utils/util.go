@@ -1,12 +1,35 @@
 package utils
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
 )
 
+// IsTerminal 判断文件是否连接到交互式终端（cron、systemd、管道等场景返回 false）
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// SleepContext 睡眠 d，ctx 提前结束时返回 ctx.Err()
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func PathExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil || errors.Is(err, os.ErrExist)
@@ -22,11 +45,11 @@ func GetFileSize(path string) (int64, error) {
 }
 
 // GetRemoteFileSize 获取远程文件大小
-func GetRemoteFileSize(url string, headers map[string]string) (int64, error) {
+func GetRemoteFileSize(ctx context.Context, url string, headers map[string]string) (int64, error) {
 	client := Client.Get().(*http.Client)
 	defer Client.Put(client)
 
-	req, err := http.NewRequest("HEAD", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -50,3 +73,51 @@ func GetRemoteFileSize(url string, headers map[string]string) (int64, error) {
 
 	return resp.ContentLength, nil
 }
+
+// ParseSize 解析 rclone 风格的大小，例如 18G、512M、1.5T、1024；单位按 1024 进制
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+	upper := strings.ToUpper(s)
+	upper = strings.TrimSuffix(upper, "IB")
+	upper = strings.TrimSuffix(upper, "B")
+
+	multiplier := int64(1)
+	if upper != "" {
+		switch upper[len(upper)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			upper = upper[:len(upper)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(upper, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatSize 以 GB/MB/KB 显示字节数
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.2f GB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.2f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.2f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
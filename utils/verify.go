@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrVerifyFailed 文件大小或校验和与预期不符
+	ErrVerifyFailed = errors.New("verification failed")
+	// ErrHashUnsupported 存储后端不支持该校验算法，跳过校验和比对
+	ErrHashUnsupported = errors.New("hash type not supported")
+)
+
+// Hasher 能够计算文件校验和的存储后端
+type Hasher interface {
+	Hash(ctx context.Context, name, hashType string) (string, error)
+}
+
+func newHash(hashType string) (hash.Hash, error) {
+	switch strings.ToLower(hashType) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrHashUnsupported, hashType)
+	}
+}
+
+// HashFile 计算本地文件的校验和（小写十六进制）
+func HashFile(path, hashType string) (string, error) {
+	h, err := newHash(hashType)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifySize 校验临时文件是否完整
+// 分块未下载完时保留进度日志以便续传，文件大小不符时说明临时文件已损坏，删除进度日志
+func (m *MultiThreadDownloader) verifySize() error {
+	for _, b := range m.Blocks {
+		if b.BeginOffset <= b.EndOffset {
+			return fmt.Errorf("%w: block %d-%d incomplete", ErrVerifyFailed, b.StartOffset, b.EndOffset)
+		}
+	}
+	if m.ContentLength <= 0 {
+		return nil
+	}
+	size, err := GetFileSize(m.FullPath)
+	if err != nil {
+		return err
+	}
+	if size != m.ContentLength {
+		m.discardProgress()
+		return fmt.Errorf("%w: size %d, expected %d", ErrVerifyFailed, size, m.ContentLength)
+	}
+	return nil
+}
+
+// verifyStored 校验写入存储后端的文件，checksum 为空时只比较大小
+func verifyStored(ctx context.Context, s Storage, name string, size int64, hashType, checksum string) error {
+	info, err := s.Stat(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerifyFailed, err)
+	}
+	if info.Size != size {
+		return fmt.Errorf("%w: remote size %d, local %d", ErrVerifyFailed, info.Size, size)
+	}
+
+	hasher, ok := s.(Hasher)
+	if checksum == "" || !ok {
+		return nil
+	}
+	remote, err := hasher.Hash(ctx, name, hashType)
+	if errors.Is(err, ErrHashUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerifyFailed, err)
+	}
+	if !strings.EqualFold(remote, checksum) {
+		return fmt.Errorf("%w: remote %s %s, local %s", ErrVerifyFailed, hashType, remote, checksum)
+	}
+	return nil
+}
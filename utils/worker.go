@@ -1,27 +1,16 @@
 package utils
 
 import (
-	"encoding/json"
-	"io"
-	"net/http"
+	"errors"
+	"fmt"
 	"os"
-	"path/filepath"
-	"strings"
+	"sort"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"re-asmr-spider/i18n"
 )
 
-// 🔥 Rclone 缓存监控配置 (基于 Rclone --vfs-cache-max-size 20GB)
-// 暂停阈值：18GB (当缓存超过此值，程序停止向挂载点移动文件)
-const RclonePauseThreshold = 18 * 1024 * 1024 * 1024
-// 恢复阈值：15GB (当缓存降到此值，程序恢复写入)
-const RcloneResumeThreshold = 15 * 1024 * 1024 * 1024
-
-// Rclone API 地址 (请确保 Rclone 挂载命令中使用了 --rc-addr 127.0.0.1:5572)
-const RcloneAPIUrl = "http://127.0.0.1:5572/vfs/stats"
-
 type WorkerChan chan *MultiThreadDownloader
 
 type WorkerPool struct {
@@ -30,13 +19,32 @@ type WorkerPool struct {
 	TaskQueue WorkerChan
 	Limit     int
 	Count     int
+	// Storage 下载完成后文件写入的存储后端
+	Storage Storage
+	// Verify 写入后检查存储后端上的文件大小（以及校验和）
+	Verify bool
+	// Checksum 校验算法 md5/sha1/sha256，为空时只校验大小
+	Checksum string
+
+	// active 已提交但尚未结束的任务，以 FinalPath 为键
+	activeMu sync.Mutex
+	active   map[string]*activeTask
 }
 
-// 定义 Rclone 返回的 JSON 结构 (已修复，嵌套到 diskCache.bytesUsed)
-type RcloneVFSStats struct {
-	DiskCache struct {
-		BytesUsed int64 `json:"bytesUsed"`
-	} `json:"diskCache"` 
+// activeTask 队列中或正在下载的任务
+type activeTask struct {
+	t       *MultiThreadDownloader
+	running bool
+}
+
+// ActiveTask 任务的当前状态，供 API 展示
+type ActiveTask struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	URL      string `json:"url"`
+	Running  bool   `json:"running"`
+	// Progress 正在下载时的进度，服务器不支持 Range 时为空
+	Progress *DownloadProgress `json:"progress,omitempty"`
 }
 
 func NewWorkerPool(WorkerCount int) *WorkerPool {
@@ -44,30 +52,103 @@ func NewWorkerPool(WorkerCount int) *WorkerPool {
 		cond:      sync.NewCond(&sync.Mutex{}),
 		Limit:     WorkerCount,
 		TaskQueue: make(WorkerChan, WorkerCount),
+		Storage:   &MountStorage{LocalStorage{Root: "."}},
+		Verify:    true,
+		active:    make(map[string]*activeTask),
 	}
 }
 
-// 🔥 新增：通过 API 获取 Rclone 当前缓存占用
-func getRcloneCacheUsage() (int64, error) {
-	// Rclone RC 接口需要 POST 请求
-	resp, err := http.Post(RcloneAPIUrl, "application/json", strings.NewReader("{}"))
-	if err != nil {
-		return 0, err
+// taskID 任务在登记表中的键
+func taskID(t *MultiThreadDownloader) string {
+	if t.FinalPath != "" {
+		return t.FinalPath
 	}
-	defer resp.Body.Close()
+	return t.FullPath
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
+// Submit 登记并加入队列，队列已满时阻塞
+// 在入队前计数，Submit 返回后调用 Wait 一定会等到这个任务结束
+func (wp *WorkerPool) Submit(t *MultiThreadDownloader) {
+	wp.Add(1)
+	wp.activeMu.Lock()
+	wp.active[taskID(t)] = &activeTask{t: t}
+	wp.activeMu.Unlock()
+	wp.TaskQueue <- t
+}
+
+// Close 不再提交任务后关闭队列，已入队的任务仍会执行（ctx 已结束的直接跳过）
+func (wp *WorkerPool) Close() {
+	close(wp.TaskQueue)
+}
+
+func (wp *WorkerPool) setRunning(t *MultiThreadDownloader) {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	if a, ok := wp.active[taskID(t)]; ok && a.t == t {
+		a.running = true
+	}
+}
+
+func (wp *WorkerPool) unregister(t *MultiThreadDownloader) {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	if a, ok := wp.active[taskID(t)]; ok && a.t == t {
+		delete(wp.active, taskID(t))
+	}
+}
+
+// Active 列出队列中和正在下载的任务
+func (wp *WorkerPool) Active() []ActiveTask {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	list := make([]ActiveTask, 0, len(wp.active))
+	for id, a := range wp.active {
+		task := ActiveTask{ID: id, FileName: a.t.FileName, URL: a.t.CurrentURL(), Running: a.running}
+		if a.running {
+			task.Progress = a.t.Progress()
+		}
+		list = append(list, task)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Stop 暂停或取消一个任务，reason 为 ErrPaused 或 ErrCanceled，任务不存在时返回 false
+// 尚未开始的任务出队时直接跳过
+func (wp *WorkerPool) Stop(id string, reason error) bool {
+	wp.activeMu.Lock()
+	a, ok := wp.active[id]
+	wp.activeMu.Unlock()
+	if !ok {
+		return false
 	}
+	a.t.Stop(reason)
+	return true
+}
 
-	var stats RcloneVFSStats
-	if err := json.Unmarshal(body, &stats); err != nil {
-		return 0, err
+// SetLimit 修改同时下载的任务数，正在下载的任务不受影响
+func (wp *WorkerPool) SetLimit(n int) {
+	if n <= 0 {
+		return
 	}
+	wp.cond.L.Lock()
+	wp.Limit = n
+	wp.cond.Broadcast()
+	wp.cond.L.Unlock()
+}
 
-	// 返回嵌套结构中的 BytesUsed
-	return stats.DiskCache.BytesUsed, nil
+// GetLimit 同时下载的任务数
+func (wp *WorkerPool) GetLimit() int {
+	wp.cond.L.Lock()
+	defer wp.cond.L.Unlock()
+	return wp.Limit
+}
+
+// Running 正在下载的任务数
+func (wp *WorkerPool) Running() int {
+	wp.cond.L.Lock()
+	defer wp.cond.L.Unlock()
+	return wp.Count
 }
 
 func (wp *WorkerPool) Start() {
@@ -77,13 +158,12 @@ func (wp *WorkerPool) Start() {
 			for wp.Count >= wp.Limit {
 				wp.cond.Wait()
 			}
-			wp.Add(1)
+			// 在启动前计数，否则下一轮检查时新任务可能还没有计入
+			wp.Count++
 			wp.cond.L.Unlock()
 			go func(t *MultiThreadDownloader) {
-				wp.cond.L.Lock()
-				wp.Count++
-				wp.cond.L.Unlock()
 				defer func() {
+					wp.unregister(t)
 					wp.cond.L.Lock()
 					wp.Count--
 					wp.Done()
@@ -91,97 +171,103 @@ func (wp *WorkerPool) Start() {
 					wp.cond.L.Unlock()
 				}()
 
+				// 排队期间已被暂停、取消或 ctx 已结束
+				if reason := t.StopReason(); reason != nil {
+					if t.OnFailure != nil {
+						t.OnFailure(t.Url, t.SavePath, t.FileName, reason)
+					}
+					return
+				}
+				wp.setRunning(t)
+
 				// 更新活动时间
 				GlobalMonitor.UpdateActivity()
+				if t.OnStart != nil {
+					t.OnStart()
+				}
 
 				// 1. 下载到本地临时目录
 				err := t.Download()
 				if err != nil {
-					Error(i18n.T("download_error", t.FullPath, err))
-					_ = os.Remove(t.FullPath)
+					if t.StopReason() != nil {
+						Info(i18n.T("download_stopped", t.FileName, err))
+					} else {
+						atomic.AddInt64(&filesFailed, 1)
+						Error(i18n.T("download_error", t.FullPath, err))
+					}
+					// 有进度日志时保留临时文件，重试时只下载缺失部分
+					if !t.Resumable() {
+						_ = os.Remove(t.FullPath)
+					}
 					GlobalMonitor.UpdateActivity()
 					if t.OnFailure != nil {
 						t.OnFailure(t.Url, t.SavePath, t.FileName, err)
 					}
 					return
 				}
+				if t.OnStaged != nil {
+					t.OnStaged()
+				}
 
-				// 2. 智能流控与移动文件
-				if t.FinalPath != "" && t.FinalPath != t.FullPath {
-					
-					// 🔥🔥 Rclone 缓存监控流控 🔥🔥
-					for {
-						usage, err := getRcloneCacheUsage()
-						if err != nil {
-							// 连接失败，打印错误并暂停，避免误判
-							Error("无法连接 Rclone API (请确认已添加 --rc 参数): %v", err)
-							time.Sleep(10 * time.Second)
-							GlobalMonitor.UpdateActivity()
-							continue
-						}
-
-						usageGB := float64(usage) / 1024 / 1024 / 1024
-
-						// 如果当前缓存超过暂停阈值 (18GB)
-						if usage > RclonePauseThreshold {
-							Warning("Rclone 缓存爆满 (当前: %.2f GB), 暂停移动文件...", usageGB)
-							
-							// 进入等待模式，直到缓存降到恢复阈值 (10GB) 以下
-							for {
-								time.Sleep(10 * time.Second)
-								GlobalMonitor.UpdateActivity()
-								
-								newUsage, err := getRcloneCacheUsage()
-								if err == nil {
-									if newUsage < RcloneResumeThreshold {
-										Success("Rclone 缓存已清理 (当前: %.2f GB), 恢复运行", float64(newUsage)/1024/1024/1024)
-										break // 退出内部等待循环
-									}
-								}
-							}
-							break // 退出外部检查循环
+				// 2. 写入存储后端（挂载点会先做缓存流控），并校验写入结果
+				if t.FinalPath != "" {
+					// 移动失败时保留临时文件，重试时直接复用
+					if err := wp.store(t); err != nil {
+						// 等待缓存时被中断，临时文件已完整，下次直接移动
+						if reason := t.StopReason(); reason != nil {
+							err = reason
+							Info(i18n.T("download_stopped", t.FileName, err))
 						} else {
-							// 缓存未满，直接通过
-							break
+							err = fmt.Errorf("%w: %v", ErrMoveFailed, err)
+							atomic.AddInt64(&filesFailed, 1)
+							Error(i18n.T("download_error", wp.Storage.Location(t.FinalPath), err))
 						}
-					}
-					// 🔥🔥 流控结束 🔥🔥
-
-					// 确保目标文件夹存在
-					if err := os.MkdirAll(filepath.Dir(t.FinalPath), 0755); err != nil {
-						Error(i18n.T("download_error", "Mkdir FinalPath", err))
-					} else {
-						// 移动文件 (复制+删除)
-						srcFile, err := os.Open(t.FullPath)
-						if err == nil {
-							dstFile, err := os.Create(t.FinalPath)
-							if err == nil {
-								_, copyErr := io.Copy(dstFile, srcFile)
-								srcFile.Close()
-								dstFile.Close()
-								
-								if copyErr == nil {
-									os.Remove(t.FullPath) // 成功后删除本地临时文件
-								} else {
-									Error("写入挂载点失败: %v", copyErr)
-								}
-							} else {
-								srcFile.Close()
-								Error("无法创建目标文件: %v", err)
-							}
-						} else {
-							Error("无法打开源文件: %v", err)
+						GlobalMonitor.UpdateActivity()
+						if t.OnFailure != nil {
+							t.OnFailure(t.Url, t.SavePath, t.FileName, err)
 						}
+						return
 					}
+					// 成功后删除本地临时文件（本地存储重命名时已不存在）
+					_ = os.Remove(t.FullPath)
 				}
 
+				atomic.AddInt64(&filesCompleted, 1)
+				if t.OnSuccess != nil {
+					t.OnSuccess()
+				}
 				GlobalMonitor.UpdateActivity()
 				displayPath := t.FullPath
 				if t.FinalPath != "" {
-					displayPath = t.FinalPath
+					displayPath = wp.Storage.Location(t.FinalPath)
 				}
 				Success(i18n.T("download_completed", displayPath))
 			}(t)
 		}
 	}()
 }
+
+// store 写入存储后端并校验，本地存储重命名后临时文件不再存在，所以先记下大小和校验和
+func (wp *WorkerPool) store(t *MultiThreadDownloader) error {
+	size, err := GetFileSize(t.FullPath)
+	if err != nil {
+		return err
+	}
+	if wp.Checksum != "" {
+		sum, err := HashFile(t.FullPath, wp.Checksum)
+		if err != nil {
+			return err
+		}
+		t.Checksum = sum
+	}
+	if err := wp.Storage.Put(t.ctx, t.FullPath, t.FinalPath); err != nil {
+		return err
+	}
+	if !wp.Verify {
+		return nil
+	}
+	return verifyStored(t.ctx, wp.Storage, t.FinalPath, size, wp.Checksum, t.Checksum)
+}
+
+// ErrMoveFailed 文件已下载到临时目录，但移动到最终路径失败
+var ErrMoveFailed = errors.New("move to final path failed")